@@ -0,0 +1,204 @@
+package resolvconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Builder constructs File from scratch. Methods can be chained, errors are
+// reported by Build.
+//
+//	f, err := new(Builder).
+//		AddNameserver(net.ParseIP("8.8.8.8")).
+//		AddSearch("example.com").
+//		Build()
+type Builder struct {
+	file          File
+	allowExcess   bool
+	nilNameserver bool
+}
+
+// AddNameserver appends ip to the list of nameservers.
+func (b *Builder) AddNameserver(ip net.IP) *Builder {
+	if ip == nil {
+		b.nilNameserver = true
+		return b
+	}
+	b.file.Nameservers = append(b.file.Nameservers, ip)
+	return b
+}
+
+// AddSearch appends domains to the search list.
+func (b *Builder) AddSearch(domains ...string) *Builder {
+	b.file.Search = append(b.file.Search, domains...)
+	return b
+}
+
+// SetDomain sets the local domain name.
+func (b *Builder) SetDomain(domain string) *Builder {
+	b.file.Domain = domain
+	return b
+}
+
+// SetOption sets option, e.g. "ndots:2" or "rotate". If option with the same
+// name is already set, it's replaced.
+func (b *Builder) SetOption(option string) *Builder {
+	b.file.Options = setOption(b.file.Options, option)
+	return b
+}
+
+// AllowExcessNameservers allows Build to return file with more than 3
+// nameservers. Note that libc uses only the first 3 of them.
+func (b *Builder) AllowExcessNameservers() *Builder {
+	b.allowExcess = true
+	return b
+}
+
+// Build validates collected directives and returns File with rendered Content
+// and its Hash. Values which can't be written to resolv.conf as is, e.g.
+// domain containing whitespace, are rejected.
+func (b *Builder) Build() (*File, error) {
+	if b.nilNameserver {
+		return nil, errors.New("nameserver: empty ip address")
+	}
+	if len(b.file.Nameservers) > maxNameservers && !b.allowExcess {
+		return nil, fmt.Errorf("too many nameservers: %v, libc uses at most %v", len(b.file.Nameservers), maxNameservers)
+	}
+
+	content, err := b.file.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return parse(content)
+}
+
+// Config is a declarative description of resolv.conf, e.g. decoded from YAML
+// or JSON configuration, see FromConfig.
+type Config struct {
+	Nameservers []string // addresses, optionally with zone and port, e.g. "fe80::1%eth0"
+	Search      []string
+	Domain      string
+	Options     []string // raw options, e.g. "ndots:2" or "rotate"
+}
+
+// FromConfig validates cfg and returns File with rendered Content and its
+// Hash. Invalid nameserver addresses, numeric options and values containing
+// whitespace are rejected, as well as lists exceeding libc limits: more than 3
+// nameservers or search list which CheckSearchLimits reports.
+func FromConfig(cfg Config) (*File, error) {
+	f := &File{}
+	servers := make([]Nameserver, 0, len(cfg.Nameservers))
+	for _, value := range cfg.Nameservers {
+		server, err := parseNameserver(value)
+		if err != nil {
+			return nil, fmt.Errorf("nameserver %q: %w", value, err)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) > maxNameservers {
+		return nil, &ValidationError{
+			Code:    CodeTooManyNameservers,
+			Value:   cfg.Nameservers[maxNameservers],
+			Message: "too many nameservers, libc uses at most " + strconv.Itoa(maxNameservers),
+		}
+	}
+	f.setServers(servers)
+
+	if cfg.Domain != "" {
+		if err := checkFields(domainKey, cfg.Domain); err != nil {
+			return nil, err
+		}
+		f.Domain = cfg.Domain
+	}
+	if err := checkFields(searchKey, cfg.Search...); err != nil {
+		return nil, err
+	}
+	f.Search = cfg.Search
+	if err := f.CheckSearchLimits(); err != nil {
+		return nil, err
+	}
+
+	if err := checkFields(optionKey, cfg.Options...); err != nil {
+		return nil, err
+	}
+	for _, option := range cfg.Options {
+		f.Options = setOption(f.Options, option)
+	}
+	if _, err := f.ParseOptions(); err != nil {
+		return nil, err
+	}
+
+	content, err := f.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return parse(content)
+}
+
+// checkFields returns error if any of values would be split into several
+// fields or lines in resolv.conf, or would start a comment. Comment markers
+// inside values are allowed, as they start a comment only after whitespace,
+// see splitComment.
+func checkFields(key string, values ...string) error {
+	for _, value := range values {
+		if value == "" {
+			return fmt.Errorf("%v: empty value", key)
+		}
+		if strings.ContainsAny(value, " \t\r\n") {
+			return fmt.Errorf("%v %q: contains whitespace", key, value)
+		}
+		if strings.ContainsAny(value[:1], "#;") {
+			return fmt.Errorf("%v %q: starts with comment marker", key, value)
+		}
+	}
+	return nil
+}
+
+// FromNameservers returns file with given nameservers and search domains, with
+// rendered Content and its Hash. Nil ips are dropped, as well as search
+// domains which can't be written to resolv.conf (empty, containing whitespace
+// or starting with comment marker), only first 3 nameservers are used.
+func FromNameservers(ips []net.IP, search []string) *File {
+	f := &File{}
+	for _, ip := range ips {
+		if ip != nil && len(f.Nameservers) < maxNameservers {
+			f.Nameservers = append(f.Nameservers, ip)
+		}
+	}
+	for _, domain := range search {
+		if checkFields(searchKey, domain) == nil {
+			f.Search = append(f.Search, domain)
+		}
+	}
+
+	// file contains only non-nil nameservers and valid search domains, so it
+	// can't fail
+	_ = f.render()
+	return f
+}
+
+// Empty returns valid file without any directives: Content is empty, Hash is
+// the hash of empty content, all slices are non-nil.
+func Empty() *File {
+	return &File{
+		Content:     []byte{},
+		Hash:        hashBytes(nil),
+		Nameservers: []net.IP{},
+		Options:     []string{},
+		OptionLines: map[string]int{},
+		Search:      []string{},
+		Sortlist:    []SortlistPair{},
+		Extras:      map[string][]string{},
+	}
+}
+
+// IsEmpty reports whether the file configures nothing: there are no
+// nameservers, search domains, domain and options. It's the case for files
+// which are not populated yet, e.g. right after boot before DHCP client runs,
+// including the ones containing only blank lines and comments.
+func (f *File) IsEmpty() bool {
+	return len(f.Nameservers) == 0 && len(f.Search) == 0 && f.Domain == "" && len(f.Options) == 0
+}
@@ -0,0 +1,71 @@
+package resolvconf
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]cacheEntry{}
+)
+
+type cacheEntry struct {
+	file    *File
+	cfg     parseConfig // options the file was parsed with
+	modTime time.Time
+	size    int64
+	expires time.Time
+}
+
+// GetCached returns the same as Get does, but caches the result for ttl, see
+// GetSpecificCached.
+func GetCached(ttl time.Duration) (*File, error) {
+	return GetSpecificCached(Path(), ttl)
+}
+
+// GetSpecificCached returns the same as GetSpecific does, but caches the result
+// for ttl. The file is re-read earlier, if its modification time or size
+// changes, or if it was cached with different opts. Errors are not cached.
+//
+// Every call returns a copy of the cached file, so it can be modified freely.
+func GetSpecificCached(path string, ttl time.Duration, opts ...Option) (*File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := newParseConfig(opts)
+	cacheMu.Lock()
+	entry, ok := cache[path]
+	cacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) && info.ModTime().Equal(entry.modTime) && info.Size() == entry.size &&
+		entry.cfg == cfg {
+		return entry.file.Clone(), nil
+	}
+
+	f, err := GetSpecific(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheMu.Lock()
+	cache[path] = cacheEntry{
+		file:    f,
+		cfg:     cfg,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		expires: time.Now().Add(ttl),
+	}
+	cacheMu.Unlock()
+	return f.Clone(), nil
+}
+
+// InvalidateCache drops all cached files, so next GetCached and
+// GetSpecificCached calls read them again.
+func InvalidateCache() {
+	cacheMu.Lock()
+	cache = map[string]cacheEntry{}
+	cacheMu.Unlock()
+}
@@ -0,0 +1,356 @@
+package resolvconf
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Equal reports whether f and other have the same semantic content, ignoring
+// comments, whitespace and other layout differences.
+//
+// Nameservers, search domains and sortlist are compared in order, since their
+// order affects resolution. Domain names are compared case-insensitively.
+// Options are compared regardless of their order, only the effective value of
+// each option is taken into account.
+func (f *File) Equal(other *File) bool {
+	if f == nil || other == nil {
+		return f == other
+	}
+
+	return equalNameservers(f, other) &&
+		strings.EqualFold(f.Domain, other.Domain) &&
+		equalDomains(f.Search, other.Search) &&
+		equalSortlist(f.Sortlist, other.Sortlist) &&
+		equalOptions(f.Options, other.Options)
+}
+
+// ResolvesEquivalently reports whether libc resolves names the same way with f
+// and other. It's less strict than Equal, differences which libc ignores are
+// ignored too:
+//
+//   - only the first 3 nameservers are compared, with their zones and ports,
+//     in order, unless both files have rotate option, which makes the order
+//     irrelevant;
+//   - search domains are compared as libc uses them: search list truncated to
+//     6 domains or domain, whichever is listed last, case-insensitively;
+//   - sortlist is compared in order;
+//   - known options are compared by their effect, see ParseOptions: absent
+//     options equal to their defaults (e.g. ndots:1), out of range values equal
+//     to the limits they are clamped to, unknown options are ignored;
+//   - legacy directives in Extras are ignored.
+func (f *File) ResolvesEquivalently(other *File) bool {
+	if f == nil || other == nil {
+		return f == other
+	}
+
+	x, _ := f.ParseOptions()
+	y, _ := other.ParseOptions()
+	x.Unknown, y.Unknown = nil, nil
+	if !reflect.DeepEqual(x, y) {
+		return false
+	}
+
+	a, b := f.usedNameservers(), other.usedNameservers()
+	if x.Rotate {
+		sort.Strings(a)
+		sort.Strings(b)
+	}
+	return reflect.DeepEqual(a, b) &&
+		equalDomains(f.searchList(), other.searchList()) &&
+		equalSortlist(f.Sortlist, other.Sortlist)
+}
+
+// usedNameservers returns rendered nameservers which libc uses. Invalid ones
+// are rendered as empty strings.
+func (f *File) usedNameservers() []string {
+	servers := f.servers()[:len(f.TruncatedNameservers())]
+	rendered := make([]string, len(servers))
+	for i, server := range servers {
+		rendered[i], _ = renderNameserver(server)
+	}
+	return rendered
+}
+
+func equalNameservers(a, b *File) bool {
+	if len(a.Nameservers) != len(b.Nameservers) {
+		return false
+	}
+	serversA, serversB := a.servers(), b.servers()
+	for i := range serversA {
+		// comparing rendered form, so zones and ports are taken into account
+		x, errX := renderNameserver(serversA[i])
+		y, errY := renderNameserver(serversB[i])
+		if errX != nil || errY != nil || x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func equalDomains(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSortlist(a, b []SortlistPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Address.Equal(b[i].Address) || !net.IP(a[i].Netmask).Equal(net.IP(b[i].Netmask)) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalOptions(a, b []string) bool {
+	x, y := effectiveOptions(a), effectiveOptions(b)
+	if len(x) != len(y) {
+		return false
+	}
+	for name, option := range x {
+		if other, ok := y[name]; !ok || other != option {
+			return false
+		}
+	}
+	return true
+}
+
+// effectiveOptions returns options mapped by their names. If option is set
+// more than once, the last one wins.
+func effectiveOptions(options []string) map[string]string {
+	res := make(map[string]string, len(options))
+	for _, option := range options {
+		res[optionName(option)] = option
+	}
+	return res
+}
+
+// Changes is the semantic difference between two files, see Diff.
+type Changes struct {
+	AddedNameservers   []net.IP
+	RemovedNameservers []net.IP
+	// NameserversReordered is set when the same nameservers are listed in
+	// different order.
+	NameserversReordered bool
+	// NameserversChanged contains new entries of nameservers, which are listed
+	// in both files, but with different zone or port, e.g. 8.8.8.8 replaced
+	// with 8.8.8.8:5353.
+	NameserversChanged []Nameserver
+
+	DomainChanged   bool
+	SearchChanged   bool
+	SortlistChanged bool
+
+	OptionsAdded   []string
+	OptionsRemoved []string
+	// OptionsChanged contains new values of options, which are set in both
+	// files, but with different values, e.g. ndots:2 replaced with ndots:5.
+	OptionsChanged []string
+}
+
+// IsZero reports whether there are no changes.
+func (c Changes) IsZero() bool {
+	return len(c.AddedNameservers) == 0 && len(c.RemovedNameservers) == 0 && !c.NameserversReordered &&
+		len(c.NameserversChanged) == 0 && !c.DomainChanged && !c.SearchChanged && !c.SortlistChanged &&
+		len(c.OptionsAdded) == 0 && len(c.OptionsRemoved) == 0 && len(c.OptionsChanged) == 0
+}
+
+// Diff compares old and new files semantically, the same way as Equal does,
+// and reports what has changed. Nil file is treated as empty one.
+//
+// Nameservers are compared by ip, repeated ones are counted, so removing a
+// duplicate is reported as removed nameserver.
+func Diff(old, new *File) Changes {
+	if old == nil {
+		old = &File{}
+	}
+	if new == nil {
+		new = &File{}
+	}
+
+	var c Changes
+	c.AddedNameservers = missingIPs(new.Nameservers, old.Nameservers)
+	c.RemovedNameservers = missingIPs(old.Nameservers, new.Nameservers)
+	if len(c.AddedNameservers) == 0 && len(c.RemovedNameservers) == 0 {
+		// lists are permutations of each other
+		c.NameserversReordered = !equalIPs(old.Nameservers, new.Nameservers)
+	}
+	c.NameserversChanged = changedServers(old.servers(), new.servers())
+
+	c.DomainChanged = !strings.EqualFold(old.Domain, new.Domain)
+	c.SearchChanged = !equalDomains(old.Search, new.Search)
+	c.SortlistChanged = !equalSortlist(old.Sortlist, new.Sortlist)
+
+	oldOptions, newOptions := effectiveOptions(old.Options), effectiveOptions(new.Options)
+	// iterating over slices instead of maps to keep the result deterministic
+	for _, option := range new.Options {
+		name := optionName(option)
+		if newOptions[name] != option {
+			continue // overridden later
+		}
+		switch previous, ok := oldOptions[name]; {
+		case !ok:
+			c.OptionsAdded = append(c.OptionsAdded, option)
+		case previous != option:
+			c.OptionsChanged = append(c.OptionsChanged, option)
+		}
+	}
+	for _, option := range old.Options {
+		name := optionName(option)
+		if _, ok := newOptions[name]; !ok && oldOptions[name] == option {
+			c.OptionsRemoved = append(c.OptionsRemoved, option)
+		}
+	}
+
+	return c
+}
+
+// missingIPs returns ips from a which are not in b. Repeated ips are counted,
+// so ip listed twice in a and once in b is returned once.
+func missingIPs(a, b []net.IP) []net.IP {
+	matched := make([]bool, len(b))
+	var res []net.IP
+	for _, ip := range a {
+		if i := indexOfIP(b, ip, matched); i != -1 {
+			matched[i] = true
+		} else {
+			res = append(res, ip)
+		}
+	}
+	return res
+}
+
+// indexOfIP returns index of the first ip in ips, which is not matched yet,
+// or -1 if there is none.
+func indexOfIP(ips []net.IP, ip net.IP, matched []bool) int {
+	for i, existing := range ips {
+		if !matched[i] && existing.Equal(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// changedServers returns servers of new, which are listed in old with the
+// same ip, but different zone or port. Repeated ips are matched in order.
+func changedServers(old, new []Nameserver) []Nameserver {
+	ips := nameserverIPs(old)
+	matched := make([]bool, len(old))
+	var res []Nameserver
+	for _, server := range new {
+		i := indexOfIP(ips, server.IP, matched)
+		if i == -1 {
+			continue // added
+		}
+		matched[i] = true
+		if old[i].Zone != server.Zone || old[i].Port != server.Port {
+			res = append(res, server)
+		}
+	}
+	return res
+}
+
+// UnifiedDiff returns unified diff of canonical forms of old and new (see
+// Canonicalize), e.g. for logs and reviews:
+//
+//	--- old
+//	+++ new
+//	@@ -1,2 +1,2 @@
+//	-nameserver 1.1.1.1
+//	+nameserver 8.8.8.8
+//	 search example.com
+//
+// Files are tiny, so the whole file is a single hunk. Empty string is returned
+// if canonical forms are the same. Nil file is treated as empty one, old and
+// new are not modified.
+func UnifiedDiff(old, new *File) string {
+	a, b := canonicalLines(old), canonicalLines(new)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:], b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	if lcs[0][0] == len(a) && len(a) == len(b) {
+		return ""
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- old\n+++ new\n@@ -%v +%v @@\n", hunkRange(len(a)), hunkRange(len(b)))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			buf.WriteString(" " + a[i] + "\n")
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			buf.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			buf.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return buf.String()
+}
+
+// canonicalLines returns lines of canonical form of f.
+func canonicalLines(f *File) []string {
+	if f == nil {
+		return nil
+	}
+
+	c := f.Clone()
+	c.Canonicalize()
+	content := strings.TrimSuffix(c.String(), "\n")
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+// hunkRange returns range of unified diff hunk covering the whole file of n
+// lines.
+func hunkRange(n int) string {
+	if n == 0 {
+		return "0,0"
+	}
+	return "1," + strconv.Itoa(n)
+}
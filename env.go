@@ -0,0 +1,48 @@
+package resolvconf
+
+import (
+	"os"
+	"strings"
+)
+
+// environment variables which override resolv.conf content, see resolv.conf(5).
+const (
+	localDomainEnv = "LOCALDOMAIN"
+	resOptionsEnv  = "RES_OPTIONS"
+)
+
+// pathEnv overrides the path returned by Path.
+const pathEnv = "RESOLVCONF_PATH"
+
+// GetWithEnv returns the same as Get does, but applies environment overrides
+// the same way as libc does:
+//
+//   - LOCALDOMAIN is a space-separated list of domains, which replaces the
+//     search list.
+//   - RES_OPTIONS is a space-separated list of options, which are merged into
+//     options, overriding the ones with the same name.
+//
+// Content and Hash still describe the file on disk.
+func GetWithEnv() (*File, error) {
+	f, err := Get()
+	if err != nil {
+		return nil, err
+	}
+
+	f.applyEnv(os.LookupEnv)
+	return f, nil
+}
+
+func (f *File) applyEnv(lookupEnv func(string) (string, bool)) {
+	if domains, ok := lookupEnv(localDomainEnv); ok {
+		f.Search = strings.Fields(domains)
+		f.domainLast = false
+		f.dirty = true
+	}
+	if options, ok := lookupEnv(resOptionsEnv); ok {
+		for _, option := range strings.Fields(options) {
+			f.Options = setOption(f.Options, option)
+		}
+		f.dirty = true
+	}
+}
@@ -0,0 +1,20 @@
+package resolvconf
+
+import "fmt"
+
+// ParseError describes a problem with a specific part of resolv.conf.
+type ParseError struct {
+	Line int    // 1-based line number, 0 if unknown
+	Raw  string // offending text as written in the file
+	Kind string // kind of the entry, e.g. "nameserver", "sortlist" or "options"
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%v: %v: %q", e.Kind, e.Err, e.Raw)
+	}
+	return fmt.Sprintf("line %v: %v: %q", e.Line, e.Err, e.Raw)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
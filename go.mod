@@ -0,0 +1,13 @@
+module github.com/quenbyako/resolvconf
+
+go 1.26.0
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/net v0.59.0
+)
+
+require (
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+)
@@ -0,0 +1,107 @@
+package resolvconf
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// fileJSON is the JSON representation of File.
+type fileJSON struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Sortlist    []string `json:"sortlist,omitempty"`
+	Options     []string `json:"options,omitempty"`
+	Hash        string   `json:"hash,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Nameservers and sortlist pairs are
+// rendered as strings, the same way as they're written in resolv.conf. Raw
+// Content is omitted. If both domain and search are set, only the one which
+// libc uses is rendered, the same way as NormalizeDomainSearch keeps it, since
+// JSON doesn't keep the order of directives.
+func (f *File) MarshalJSON() ([]byte, error) {
+	v := fileJSON{
+		Domain:  f.Domain,
+		Search:  f.Search,
+		Options: f.Options,
+		Hash:    f.Hash,
+	}
+	if v.Domain != "" && len(v.Search) > 0 {
+		if f.domainLast {
+			v.Search = nil
+		} else {
+			v.Domain = ""
+		}
+	}
+	for _, server := range f.servers() {
+		nameserver, err := renderNameserver(server)
+		if err != nil {
+			return nil, err
+		}
+		v.Nameservers = append(v.Nameservers, nameserver)
+	}
+
+	var err error
+	if v.Sortlist, err = f.renderSortlist(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Values are validated the same way
+// as resolv.conf content is, Content and Hash are rendered from them, hash in
+// the input is ignored. Empty values, values containing whitespace and values
+// starting with comment marker are rejected, as they can't be written to
+// resolv.conf as is.
+func (f *File) UnmarshalJSON(data []byte) error {
+	var v fileJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := v.check(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, nameserver := range v.Nameservers {
+		b.WriteString(directive(nameserverKey, nameserver) + "\n")
+	}
+	if v.Domain != "" {
+		b.WriteString(directive(domainKey, v.Domain) + "\n")
+	}
+	if len(v.Search) > 0 {
+		b.WriteString(directive(searchKey, v.Search...) + "\n")
+	}
+	if len(v.Sortlist) > 0 {
+		b.WriteString(directive(sortlistKey, v.Sortlist...) + "\n")
+	}
+	if len(v.Options) > 0 {
+		b.WriteString(directive(optionKey, v.Options...) + "\n")
+	}
+
+	parsed, err := ParseString(b.String())
+	if err != nil {
+		return err
+	}
+	*f = *parsed
+	return nil
+}
+
+// check returns error if any of values would be split into several fields or
+// lines of resolv.conf, see checkFields.
+func (v *fileJSON) check() error {
+	if v.Domain != "" {
+		if err := checkFields(domainKey, v.Domain); err != nil {
+			return err
+		}
+	}
+
+	keys := []string{nameserverKey, searchKey, sortlistKey, optionKey}
+	for i, values := range [][]string{v.Nameservers, v.Search, v.Sortlist, v.Options} {
+		if err := checkFields(keys[i], values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package resolvconf
+
+import (
+	"io"
+	"strings"
+)
+
+// line is a single line of parsed resolv.conf.
+//
+// Lines of known directives are re-rendered from the fields of File, all other
+// lines (blank, comments, unknown directives) are kept as is.
+type line struct {
+	raw     string // the whole line without line break
+	key     string // directive keyword, empty for blank and comment-only lines
+	content string // directive without comment, with normalized whitespace
+	comment string // trailing comment with whitespace before it
+}
+
+// newLine parses raw line and returns it with its fields, which are split by
+// whitespace after stripping the comment.
+func newLine(raw string) (line, []string) {
+	content, comment := splitComment(raw, commentMarks...)
+	fields := strings.Fields(content)
+	l := line{raw: raw, content: strings.Join(fields, " "), comment: comment}
+	if len(fields) > 0 {
+		l.key = fields[0]
+	}
+	return l, fields
+}
+
+// renderLayout writes directives into w, keeping the layout of lines.
+//
+// Directive lines which are not changed keep their place and trailing comment.
+// Changed lines are replaced in place, new lines are inserted after the last
+// line of the same directive, or appended to the end if there were no such
+// lines. Lines of removed directives are dropped.
+func renderLayout(w *lineWriter, lines []line, directives map[string][]string, order []string) {
+	last := make(map[string]int, len(directives))
+	for i, l := range lines {
+		if _, ok := directives[l.key]; ok {
+			last[l.key] = i
+		}
+	}
+
+	for i, l := range lines {
+		pending, ok := directives[l.key]
+		if !ok {
+			w.writeLine(l.raw)
+			continue
+		}
+
+		switch keep := indexOf(pending, l.content); {
+		case keep != -1:
+			// line is unchanged, all new lines before it are inserted here
+			for _, directive := range pending[:keep] {
+				w.writeLine(directive)
+			}
+			w.writeLine(l.raw)
+			pending = pending[keep+1:]
+		case len(pending) > 0 && !laterLineHas(lines[i+1:], l.key, pending[0]):
+			// line is changed, replacing it in place
+			w.writeLine(pending[0] + l.comment)
+			pending = pending[1:]
+		}
+
+		if last[l.key] == i {
+			for _, directive := range pending {
+				w.writeLine(directive)
+			}
+			pending = nil
+		}
+		directives[l.key] = pending
+	}
+
+	for _, key := range order {
+		if _, ok := last[key]; ok {
+			continue
+		}
+		for _, directive := range directives[key] {
+			w.writeLine(directive)
+		}
+	}
+}
+
+func indexOf(items []string, item string) int {
+	for i, existing := range items {
+		if existing == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// laterLineHas reports whether lines contain directive with key and content.
+func laterLineHas(lines []line, key, content string) bool {
+	for _, l := range lines {
+		if l.key == key && l.content == content {
+			return true
+		}
+	}
+	return false
+}
+
+// lineWriter writes lines to w and counts written bytes. After the first error
+// all writes are skipped, the error is kept in err.
+type lineWriter struct {
+	w   io.Writer
+	n   int
+	err error
+}
+
+func (lw *lineWriter) writeLine(text string) {
+	if lw.err != nil {
+		return
+	}
+	n, err := io.WriteString(lw.w, text+"\n")
+	lw.n += n
+	lw.err = err
+}
@@ -0,0 +1,33 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package resolvconf
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes exclusive advisory lock on the file at path, creating it if
+// needed, and returns the function releasing the lock. It blocks until the
+// lock is taken.
+func lockFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %v: %w", path, err)
+	}
+
+	return func() error {
+		// closing the descriptor releases the lock too, unlocking explicitly
+		// to report errors
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
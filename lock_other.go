@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package resolvconf
+
+// lockFile always fails on platforms without flock.
+func lockFile(path string) (unlock func() error, err error) {
+	return nil, ErrLockUnsupported
+}
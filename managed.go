@@ -0,0 +1,88 @@
+package resolvconf
+
+import "strings"
+
+// managers are the tools generating resolv.conf and markers they write in the
+// header comment, in order of checking.
+var managers = []struct {
+	name   string
+	marker string // lowercase
+}{
+	{name: "systemd-resolved", marker: "systemd-resolved"},
+	{name: "NetworkManager", marker: "generated by networkmanager"},
+	{name: "resolvconf", marker: "resolvconf(8)"},
+	{name: "dhcpcd", marker: "generated by dhcpcd"},
+}
+
+// ManagedBy detects the tool which generated the file by its leading comment
+// lines, e.g. "# Generated by NetworkManager", and returns its name:
+// "systemd-resolved", "NetworkManager", "resolvconf" or "dhcpcd". Such files
+// are overwritten by their managers, so they usually shouldn't be written
+// directly.
+//
+// Only comments before the first directive are inspected, files built from
+// scratch are never managed.
+func (f *File) ManagedBy() (string, bool) {
+	for _, l := range f.lines {
+		if l.key != "" {
+			break
+		}
+
+		comment := strings.ToLower(l.comment)
+		for _, manager := range managers {
+			if strings.Contains(comment, manager.marker) {
+				return manager.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CommentMeta returns metadata which managers write in comments as key=value
+// pairs, one per comment, e.g. "# interface=eth0". Key is the text before the
+// first "=", with whitespace around it trimmed, it must be a single word of
+// letters, digits, "-", "_" and ".". Value is the rest of the comment, with
+// surrounding whitespace trimmed. Other comments are ignored. If key is
+// repeated, the last value wins.
+//
+// Returns nil if there is no metadata, files built from scratch have none.
+func (f *File) CommentMeta() map[string]string {
+	var meta map[string]string
+	for _, l := range f.lines {
+		key, value, ok := commentPair(l.comment)
+		if !ok {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[key] = value
+	}
+	return meta
+}
+
+// commentPair parses comment with its marker as key=value pair.
+func commentPair(comment string) (key, value string, ok bool) {
+	text := strings.TrimSpace(comment)
+	for _, marker := range commentMarks {
+		if strings.HasPrefix(text, marker) {
+			text = strings.TrimSpace(text[len(marker):])
+			break
+		}
+	}
+
+	i := strings.Index(text, "=")
+	if i == -1 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(text[:i])
+	if key == "" {
+		return "", "", false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return "", "", false
+		}
+	}
+	return key, strings.TrimSpace(text[i+1:]), true
+}
@@ -0,0 +1,288 @@
+package resolvconf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Marshal renders the file into resolv.conf format.
+//
+// Files built from scratch are written in the same order of directives:
+//
+//	nameserver (one line per nameserver, in order of Nameservers)
+//	domain
+//	search
+//	sortlist
+//	options
+//	lookup, family (see Extras)
+//
+// Empty directives are omitted. Since domain is written before search, search
+// list wins when the result is read by libc, same as it does for Search field.
+// If domain was listed after search in the parsed file, it's written after
+// search instead, unless search domains were added since then, e.g. by
+// EnsureSearch. Either way the result is read by libc the same way as
+// QualifyName describes.
+//
+// Parsed files keep their layout: comments, blank lines and unknown directives
+// stay in place, unchanged directives keep their inline comments, changed ones
+// are replaced in place and new ones are added after the directives of the
+// same kind, or to the end of file, in the order above.
+//
+// Addresses are always written in canonical form of net.IP.String, e.g.
+// 2001:db8::0:1 is written as 2001:db8::1, so the output is diff-stable.
+func (f *File) Marshal() ([]byte, error) {
+	return f.MarshalWith(MarshalOptions{})
+}
+
+// MarshalOptions controls how MarshalWith renders the file.
+type MarshalOptions struct {
+	// OnePerLine writes every option on its own options line instead of a
+	// single line with all of them. libc applies options lines cumulatively,
+	// later ones overriding earlier ones, so options are written in order of
+	// Options and the result has the same meaning as the single line.
+	OnePerLine bool
+}
+
+// MarshalWith is the same as Marshal, but renders the file according to opts.
+func (f *File) MarshalWith(opts MarshalOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := f.marshalTo(&buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalTo writes the same bytes as Marshal returns to w, line by line, and
+// returns the number of bytes written. If the file can't be marshaled, nothing
+// is written.
+func (f *File) MarshalTo(w io.Writer) (int, error) {
+	return f.marshalTo(w, MarshalOptions{})
+}
+
+func (f *File) marshalTo(w io.Writer, opts MarshalOptions) (int, error) {
+	directives, err := f.directives(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	lw := &lineWriter{w: w}
+	lines, order := f.searchOrder(f.lines)
+	if lines != nil {
+		renderLayout(lw, lines, directives, order)
+		return lw.n, lw.err
+	}
+
+	for _, key := range order {
+		for _, directive := range directives[key] {
+			lw.writeLine(directive)
+		}
+	}
+	return lw.n, lw.err
+}
+
+// Canonicalize rewrites the file into canonical form, which doesn't depend on
+// how the file was written, so it's suitable for diffs and drift detection.
+// Meaning of the file for libc is kept. Canonicalize is idempotent.
+//
+// In canonical form:
+//
+//   - layout of the original file is dropped, including comments and unknown
+//     directives, so Marshal writes directives in the order described there;
+//   - repeated nameservers and search domains are removed, see
+//     DedupeNameservers and DedupeSearch;
+//   - only one of domain and search is kept, see NormalizeDomainSearch;
+//   - options are written on a single line, sorted by name, the last value of
+//     repeated options is kept;
+//   - addresses are written the way Marshal writes them.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) Canonicalize() {
+	f.DedupeNameservers()
+	f.DedupeSearch()
+	f.NormalizeDomainSearch()
+
+	options := make([]string, 0, len(f.Options))
+	for _, option := range f.Options {
+		options = setOption(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool { return optionName(options[i]) < optionName(options[j]) })
+	f.Options = options
+
+	f.lines = nil
+	f.dirty = true
+}
+
+// directiveOrder is the order of directives written by Marshal.
+var directiveOrder = []string{nameserverKey, domainKey, searchKey, sortlistKey, optionKey, lookupKey, familyKey}
+
+// domainLastOrder is directiveOrder with domain written after search, used
+// when domain wins over search, see domainLast.
+var domainLastOrder = []string{nameserverKey, searchKey, domainKey, sortlistKey, optionKey, lookupKey, familyKey}
+
+// Legacy directives of BSD and older libc resolvers, kept in Extras.
+const (
+	lookupKey = "lookup"
+	familyKey = "family"
+)
+
+// extraKeys are directives kept in Extras.
+var extraKeys = []string{lookupKey, familyKey}
+
+// directives renders directive lines of the file, mapped by their keywords.
+// Values which would be written as several fields or lines, or would start a
+// comment, are rejected, so the result always has the meaning of the fields.
+func (f *File) directives(opts MarshalOptions) (map[string][]string, error) {
+	if err := f.checkValues(); err != nil {
+		return nil, err
+	}
+
+	directives := make(map[string][]string, len(directiveOrder))
+	for _, key := range directiveOrder {
+		directives[key] = nil
+	}
+
+	for _, server := range f.servers() {
+		nameserver, err := renderNameserver(server)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFields(nameserverKey, nameserver); err != nil {
+			return nil, err
+		}
+		directives[nameserverKey] = append(directives[nameserverKey], directive(nameserverKey, nameserver))
+	}
+
+	if f.Domain != "" {
+		directives[domainKey] = []string{directive(domainKey, f.Domain)}
+	}
+	if len(f.Search) > 0 {
+		directives[searchKey] = []string{directive(searchKey, f.Search...)}
+	}
+
+	if len(f.Sortlist) > 0 {
+		pairs, err := f.renderSortlist()
+		if err != nil {
+			return nil, err
+		}
+		directives[sortlistKey] = []string{directive(sortlistKey, pairs...)}
+	}
+
+	for _, key := range extraKeys {
+		if args := f.Extras[key]; len(args) > 0 {
+			directives[key] = []string{directive(key, args...)}
+		}
+	}
+
+	switch {
+	case len(f.Options) == 0:
+	case opts.OnePerLine:
+		for _, option := range f.Options {
+			directives[optionKey] = append(directives[optionKey], directive(optionKey, option))
+		}
+	default:
+		directives[optionKey] = []string{directive(optionKey, f.Options...)}
+	}
+
+	return directives, nil
+}
+
+// checkValues returns error if Domain, Search, Options or Extras contain
+// values which can't be written to resolv.conf as is, see checkFields.
+func (f *File) checkValues() error {
+	if f.Domain != "" {
+		if err := checkFields(domainKey, f.Domain); err != nil {
+			return err
+		}
+	}
+	if err := checkFields(searchKey, f.Search...); err != nil {
+		return err
+	}
+	if err := checkFields(optionKey, f.Options...); err != nil {
+		return err
+	}
+	for _, key := range extraKeys {
+		if err := checkFields(key, f.Extras[key]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render replaces Content with marshaled file and recomputes Hash.
+func (f *File) render() error {
+	content, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+
+	f.Content, f.Hash = content, hashBytes(content)
+	f.dirty = false
+	return nil
+}
+
+// String implements fmt.Stringer, returning the file as it's rendered by
+// Marshal. Hash and original Content are not included.
+func (f *File) String() string {
+	content, err := f.Marshal()
+	if err != nil {
+		return "invalid resolv.conf: " + err.Error()
+	}
+	return string(content)
+}
+
+// MarshalText implements encoding.TextMarshaler, the result is the same as
+// Marshal returns.
+func (f *File) MarshalText() ([]byte, error) {
+	return f.Marshal()
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It parses text as
+// resolv.conf content into f, including Content and recomputed Hash.
+func (f *File) UnmarshalText(text []byte) error {
+	parsed, err := parse(append([]byte(nil), text...))
+	if err != nil {
+		return err
+	}
+
+	*f = *parsed
+	return nil
+}
+
+// renderNameserver returns nameserver as it's written in resolv.conf.
+func renderNameserver(server Nameserver) (string, error) {
+	if server.IP == nil {
+		return "", errors.New("nameserver: empty ip address")
+	}
+
+	host := server.IP.String()
+	if server.Zone != "" {
+		host += "%" + server.Zone
+	}
+	if server.Port == "" {
+		return host, nil
+	}
+	return net.JoinHostPort(host, server.Port), nil
+}
+
+// renderSortlist returns sortlist pairs as they're written in resolv.conf.
+func (f *File) renderSortlist() ([]string, error) {
+	pairs := make([]string, len(f.Sortlist))
+	for i, pair := range f.Sortlist {
+		if pair.Address == nil {
+			return nil, errors.New("sortlist: empty address")
+		}
+		pairs[i] = pair.Address.String()
+		if pair.Netmask != nil {
+			pairs[i] += "/" + net.IP(pair.Netmask).String()
+		}
+	}
+	return pairs, nil
+}
+
+func directive(key string, args ...string) string {
+	return key + " " + strings.Join(args, " ")
+}
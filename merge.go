@@ -0,0 +1,73 @@
+package resolvconf
+
+import "strings"
+
+// Merge combines base file with override one and returns the new file. Both
+// arguments are left unchanged, nil file is treated as empty one.
+//
+// Precedence rules:
+//
+//   - nameservers: override ones first, then base ones; duplicates are
+//     removed and the list is capped at 3 (MAXNS).
+//   - domain: override one if set, otherwise base one.
+//   - search: override domains first, then base ones; duplicates are removed
+//     (case-insensitively) and the list is capped at 6 (MAXDNSRCH).
+//   - sortlist: override one if set, otherwise base one.
+//   - options: base options, then override ones; override option replaces
+//     base option with the same name (e.g. ndots:2 replaces ndots:5).
+//
+// Content and Hash of the result are rendered from merged directives.
+func Merge(base, override *File) *File {
+	// cloning, so the result doesn't share ip addresses with arguments
+	base, override = base.Clone(), override.Clone()
+	if base == nil {
+		base = &File{}
+	}
+	if override == nil {
+		override = &File{}
+	}
+
+	res := &File{}
+
+	var servers []Nameserver
+	for _, server := range append(override.servers(), base.servers()...) {
+		if len(servers) < maxNameservers && server.IP != nil && !containsServer(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	res.setServers(servers)
+
+	res.Domain = override.Domain
+	if res.Domain == "" {
+		res.Domain = base.Domain
+	}
+
+	for _, domain := range append(append([]string{}, override.Search...), base.Search...) {
+		if len(res.Search) < maxSearch && !containsDomain(res.Search, domain) {
+			res.Search = append(res.Search, domain)
+		}
+	}
+
+	res.Sortlist = append(res.Sortlist, override.Sortlist...)
+	if len(res.Sortlist) == 0 {
+		res.Sortlist = append(res.Sortlist, base.Sortlist...)
+	}
+
+	for _, option := range append(append([]string{}, base.Options...), override.Options...) {
+		res.Options = setOption(res.Options, option)
+	}
+
+	// all nameservers are non-nil, so only invalid sortlist can fail here,
+	// and it's kept as is: Content stays empty in this case
+	_ = res.render()
+	return res
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, existing := range domains {
+		if strings.EqualFold(existing, domain) {
+			return true
+		}
+	}
+	return false
+}
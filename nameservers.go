@@ -0,0 +1,299 @@
+package resolvconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrOnlyLoopbackNameservers is returned by RemoveLoopbackNameservers when all
+// nameservers are loopback addresses.
+var ErrOnlyLoopbackNameservers = errors.New("all nameservers are loopback addresses")
+
+// ErrStubLoop describes the problem detected by DetectStubLoop, so callers can
+// report actionable error instead of timing out.
+var ErrStubLoop = errors.New("all nameservers are systemd-resolved stub, which can't be reached from a container, use upstream nameservers instead, e.g. from " + alternatePath)
+
+// AddNameserver appends ip to the list of nameservers, if it's not there yet.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) AddNameserver(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	for _, existing := range f.Nameservers {
+		if existing.Equal(ip) {
+			return
+		}
+	}
+
+	f.setServers(append(f.servers(), Nameserver{IP: ip}))
+}
+
+// RemoveNameserver removes ip from the list of nameservers and reports whether
+// it was there.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) RemoveNameserver(ip net.IP) bool {
+	servers := f.servers()
+	kept := servers[:0]
+	for _, server := range servers {
+		if !server.IP.Equal(ip) {
+			kept = append(kept, server)
+		}
+	}
+
+	f.setServers(kept)
+	return len(kept) != len(servers)
+}
+
+// ReplaceNameservers replaces the list of nameservers with ips, dropping
+// repeated ones and keeping at most 3, which libc uses. If any of ips is nil,
+// error is returned and nameservers are left untouched.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) ReplaceNameservers(ips []net.IP) error {
+	servers := make([]Nameserver, 0, len(ips))
+	for i, ip := range ips {
+		if ip == nil {
+			return fmt.Errorf("nameserver %v: empty ip address", i)
+		}
+		server := Nameserver{IP: ip}
+		if len(servers) < maxNameservers && !containsServer(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+
+	f.setServers(servers)
+	return nil
+}
+
+// RemoveAllNameservers removes all nameservers.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) RemoveAllNameservers() {
+	f.setServers([]Nameserver{})
+}
+
+// DedupeNameservers removes repeated nameservers, keeping the first one of
+// each. Addresses are compared with net.IP.Equal, so different spellings of
+// the same address (e.g. ::1 and 0:0:0:0:0:0:0:1) are duplicates. Link-local
+// addresses with different zones are different nameservers.
+func (f *File) DedupeNameservers() {
+	servers := f.servers()
+	kept := servers[:0]
+	for _, server := range servers {
+		if !containsServer(kept, server) {
+			kept = append(kept, server)
+		}
+	}
+
+	f.setServers(kept)
+}
+
+func containsServer(servers []Nameserver, server Nameserver) bool {
+	for _, existing := range servers {
+		if existing.IP.Equal(server.IP) && existing.Zone == server.Zone {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveLoopbackNameservers removes loopback nameservers (127.0.0.53, ::1 and
+// so on), which are useless inside a container, and returns their count.
+//
+// If all nameservers are loopback ones, they are kept untouched and
+// ErrOnlyLoopbackNameservers is returned, so caller can replace them with some
+// fallback nameserver.
+func (f *File) RemoveLoopbackNameservers() (int, error) {
+	servers := f.servers()
+	kept := make([]Nameserver, 0, len(servers))
+	for _, server := range servers {
+		if !server.IP.IsLoopback() {
+			kept = append(kept, server)
+		}
+	}
+
+	if len(kept) == 0 && len(servers) > 0 {
+		return 0, ErrOnlyLoopbackNameservers
+	}
+
+	f.setServers(kept)
+	return len(servers) - len(kept), nil
+}
+
+// Nameservers2 returns nameservers with their zones, ports and addresses as
+// they're written in the file (see Nameserver.Raw), in order of Nameservers.
+// Entries added after parsing have empty Raw. The returned slice is a copy,
+// modifying it doesn't affect f.
+func (f *File) Nameservers2() []Nameserver {
+	return f.servers()
+}
+
+// servers returns nameservers with zones and ports matching current
+// Nameservers. Since Nameservers can be modified directly, e.g. re-sliced,
+// entries are matched by ip, repeated ips are matched in order. Nameservers
+// without matching entry are returned without zone and port.
+func (f *File) servers() []Nameserver {
+	servers := make([]Nameserver, len(f.Nameservers))
+	matched := make([]bool, len(f.serverDetails))
+	for i, ip := range f.Nameservers {
+		servers[i] = Nameserver{IP: ip}
+		for j, server := range f.serverDetails {
+			if !matched[j] && server.IP.Equal(ip) {
+				servers[i], matched[j] = server, true
+				break
+			}
+		}
+	}
+	return servers
+}
+
+// setServers replaces both nameservers and their details and marks the file
+// dirty, if they're changed.
+func (f *File) setServers(servers []Nameserver) {
+	if !equalServers(f.servers(), servers) {
+		f.dirty = true
+	}
+	f.serverDetails = servers
+	f.Nameservers = nameserverIPs(servers)
+}
+
+func equalServers(a, b []Nameserver) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IP.Equal(b[i].IP) || a[i].Zone != b[i].Zone || a[i].Port != b[i].Port {
+			return false
+		}
+	}
+	return true
+}
+
+// TruncatedNameservers returns the nameservers which libc actually uses, which
+// are the first 3 of them, or the first n if the file was parsed with
+// WithMaxNameservers(n). Others are silently ignored at resolve time.
+func (f *File) TruncatedNameservers() []net.IP {
+	if limit := f.nameserverLimit(); len(f.Nameservers) > limit {
+		return f.Nameservers[:limit]
+	}
+	return f.Nameservers
+}
+
+// nameserverLimit returns the count of nameservers used by the resolver, see
+// WithMaxNameservers.
+func (f *File) nameserverLimit() int {
+	if f.maxNameservers > 0 {
+		return f.maxNameservers
+	}
+	return maxNameservers
+}
+
+// PrimaryNameserver returns the first nameserver, which is queried first by
+// libc unless rotate option is set. Reports false if there are no nameservers.
+func (f *File) PrimaryNameserver() (net.IP, bool) {
+	if len(f.Nameservers) == 0 {
+		return nil, false
+	}
+	return f.Nameservers[0], true
+}
+
+// PrimaryNameserverSkipLoopback is the same as PrimaryNameserver, but skips
+// loopback nameservers, e.g. systemd-resolved stub which is unreachable inside
+// a container.
+func (f *File) PrimaryNameserverSkipLoopback() (net.IP, bool) {
+	for _, ip := range f.Nameservers {
+		if !ip.IsLoopback() {
+			return ip, true
+		}
+	}
+	return nil, false
+}
+
+// CanResolve reports whether the file is usable for DNS inside a container:
+// at least one of the nameservers libc uses (see TruncatedNameservers) is not
+// a loopback address and is not reported by SuspiciousNameservers. Loopback
+// nameservers, like systemd-resolved stub 127.0.0.53, are reachable only in
+// the network namespace of the host. See CanResolveAllowLoopback to accept
+// them.
+//
+// libc falls back to a nameserver on localhost when there are none, but
+// CanResolve reports false for such files, as they're not configured.
+func (f *File) CanResolve() bool {
+	return f.canResolve(false)
+}
+
+// CanResolveAllowLoopback is the same as CanResolve, but loopback nameservers
+// are usable too, e.g. when the caller shares the network namespace with a
+// local resolver.
+func (f *File) CanResolveAllowLoopback() bool {
+	return f.canResolve(true)
+}
+
+func (f *File) canResolve(allowLoopback bool) bool {
+	for _, ip := range f.TruncatedNameservers() {
+		if (allowLoopback || !ip.IsLoopback()) && !isUnroutable(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectStubLoop reports whether all nameservers are systemd-resolved stub
+// (StubResolverAddress), e.g. when the file detected by Path still lists only
+// 127.0.0.53. Inside a container queries to it go nowhere, so nothing can be
+// resolved, see ErrStubLoop. Files without nameservers are not reported.
+func (f *File) DetectStubLoop() bool {
+	for _, ip := range f.Nameservers {
+		if !ip.Equal(StubResolverAddress) {
+			return false
+		}
+	}
+	return len(f.Nameservers) > 0
+}
+
+// NameserversV4 returns ipv4 nameservers. IPv4-mapped ipv6 addresses (like
+// ::ffff:1.2.3.4) are treated as ipv4, since net.IP doesn't distinguish them.
+func (f *File) NameserversV4() []net.IP {
+	var ips []net.IP
+	for _, ip := range f.Nameservers {
+		if ip.To4() != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// NameserversV6 returns ipv6 nameservers, excluding ipv4-mapped ones, see
+// NameserversV4.
+func (f *File) NameserversV6() []net.IP {
+	var ips []net.IP
+	for _, ip := range f.Nameservers {
+		if ip.To4() == nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// RotatedNameservers returns nameservers in the order they're queried when
+// options rotate is set: the list is rotated left by seed, e.g. seed 1 makes
+// the second nameserver first. Without rotate option seed is ignored and
+// nameservers are returned in order of the file.
+func (f *File) RotatedNameservers(seed int) []net.IP {
+	ips := append([]net.IP{}, f.Nameservers...)
+	if len(ips) == 0 {
+		return ips
+	}
+	if opts, _ := f.ParseOptions(); !opts.Rotate {
+		return ips
+	}
+
+	offset := seed % len(ips)
+	if offset < 0 {
+		offset += len(ips)
+	}
+	return append(ips[offset:], ips[:offset]...)
+}
@@ -0,0 +1,221 @@
+package resolvconf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// libc defaults and limits for numeric options, see resolv.conf(5).
+const (
+	defaultNdots    = 1
+	defaultTimeout  = 5
+	defaultAttempts = 2
+
+	maxNdots    = 15 // RES_MAXNDOTS
+	maxTimeout  = 30 // RES_MAXRETRANS
+	maxAttempts = 5  // RES_MAXRETRY
+
+	maxEDNS0Size = 65535 // UDP payload size is 16-bit
+)
+
+// ParsedOptions is the typed representation of options listed in resolv.conf.
+// Numeric fields are set to libc defaults, when option is not specified.
+type ParsedOptions struct {
+	Ndots    int
+	Timeout  int // in seconds
+	Attempts int
+
+	Rotate              bool // rotate
+	NoCheckNames        bool // no-check-names
+	Inet6               bool // inet6
+	EDNS0               bool // edns0
+	SingleRequest       bool // single-request
+	SingleRequestReopen bool // single-request-reopen
+	UseVC               bool // use-vc
+	NoTLDQuery          bool // no-tld-query
+	TrustAD             bool // trust-ad
+	NoReload            bool // no-reload
+
+	// EDNS0Size is the UDP payload size given as edns0:N, which some resolvers
+	// accept as a hint. It's 0 for bare edns0, meaning resolver's default.
+	EDNS0Size int
+
+	// Unknown contains options which are not recognized, as they're written.
+	Unknown []string
+}
+
+// ParseOptions parses raw options of the file into ParsedOptions.
+//
+// Numeric values which are out of range are clamped to libc limits, values
+// which are not numbers are ignored. In both cases error is returned together
+// with the options, so caller can decide to ignore it, as libc does.
+func (f *File) ParseOptions() (ParsedOptions, error) {
+	parsed := ParsedOptions{
+		Ndots:    defaultNdots,
+		Timeout:  defaultTimeout,
+		Attempts: defaultAttempts,
+	}
+
+	var firstErr error
+	for _, option := range f.Options {
+		if err := parsed.set(option); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return parsed, firstErr
+}
+
+// HasOption reports whether option key is set and returns its value, e.g.
+// "5" for ndots:5, or empty string for flags like rotate. If option is set
+// more than once, the last value wins.
+func (f *File) HasOption(key string) (value string, ok bool) {
+	for _, option := range f.Options {
+		name := optionName(option)
+		if name != key {
+			continue
+		}
+
+		value, ok = "", true
+		if name != option {
+			value = option[len(name)+1:]
+		}
+	}
+	return value, ok
+}
+
+// SetOption sets option key to value, e.g. SetOption("ndots", "2") sets
+// ndots:2, replacing any other ndots option. Empty value sets a flag, e.g.
+// SetOption("rotate", "").
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) SetOption(key, value string) {
+	option := key
+	if value != "" {
+		option += ":" + value
+	}
+
+	f.Options = setOption(f.Options, option)
+	f.dirty = true
+}
+
+// RemoveOption removes option key, whatever its value is, and reports whether
+// it was set.
+//
+// Content and Hash are not updated, use Marshal to render the new content.
+func (f *File) RemoveOption(key string) bool {
+	before := len(f.Options)
+	f.Options = removeOption(f.Options, key)
+	if len(f.Options) == before {
+		return false
+	}
+
+	delete(f.OptionLines, key)
+	f.dirty = true
+	return true
+}
+
+// Ndots returns ndots option, the count of dots in name, starting from which
+// the name is tried as is before appending search domains. Defaults to 1,
+// clamped to at most 15.
+func (f *File) Ndots() int {
+	opts, _ := f.ParseOptions()
+	return opts.Ndots
+}
+
+// Timeout returns timeout option, the time resolver waits for a response of a
+// nameserver. Defaults to 5 seconds, clamped to at most 30 seconds.
+func (f *File) Timeout() time.Duration {
+	opts, _ := f.ParseOptions()
+	return time.Duration(opts.Timeout) * time.Second
+}
+
+// Attempts returns attempts option, the number of times resolver queries the
+// nameservers before giving up. Defaults to 2, clamped to at most 5.
+func (f *File) Attempts() int {
+	opts, _ := f.ParseOptions()
+	return opts.Attempts
+}
+
+// PreferIPv6 reports whether options inet6 is set, which makes libc query AAAA
+// records before A ones, so IPv6 addresses are preferred.
+func (f *File) PreferIPv6() bool {
+	_, ok := f.HasOption("inet6")
+	return ok
+}
+
+// TrustAD reports whether options trust-ad is set, which means the
+// nameservers are trusted to validate DNSSEC, so AD bit of their responses can
+// be honored. Without it libc clears AD bit of responses.
+func (f *File) TrustAD() bool {
+	_, ok := f.HasOption("trust-ad")
+	return ok
+}
+
+func (o *ParsedOptions) set(option string) error {
+	name, value := optionName(option), ""
+	if name != option {
+		value = option[len(name)+1:]
+	}
+
+	var err error
+	switch name {
+	case "ndots":
+		err = setNumericOption(&o.Ndots, value, maxNdots)
+	case "timeout":
+		err = setNumericOption(&o.Timeout, value, maxTimeout)
+	case "attempts":
+		err = setNumericOption(&o.Attempts, value, maxAttempts)
+	case "rotate":
+		o.Rotate = true
+	case "no-check-names":
+		o.NoCheckNames = true
+	case "inet6":
+		o.Inet6 = true
+	case "edns0":
+		o.EDNS0 = true
+		if value != "" {
+			err = setNumericOption(&o.EDNS0Size, value, maxEDNS0Size)
+		}
+	case "single-request":
+		o.SingleRequest = true
+	case "single-request-reopen":
+		o.SingleRequestReopen = true
+	case "use-vc":
+		o.UseVC = true
+	case "no-tld-query":
+		o.NoTLDQuery = true
+	case "trust-ad":
+		o.TrustAD = true
+	case "no-reload":
+		o.NoReload = true
+	default:
+		o.Unknown = append(o.Unknown, option)
+	}
+
+	if err != nil {
+		return &ParseError{Raw: option, Kind: optionKey, Err: err}
+	}
+	return nil
+}
+
+func setNumericOption(dst *int, value string, limit int) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return errors.New("invalid value, expected number")
+	}
+
+	switch {
+	case n < 0:
+		*dst = 0
+		return errors.New("negative value, clamped to 0")
+	case n > limit:
+		*dst = limit
+		return fmt.Errorf("value is greater than %v, clamped to %v", limit, limit)
+	}
+
+	*dst = n
+	return nil
+}
@@ -0,0 +1,170 @@
+package resolvconf
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// byteOrderMark is UTF-8 BOM, which some editors write at the start of file.
+const byteOrderMark = "\ufeff"
+
+// Option tunes parsing of resolv.conf, see GetSpecific.
+type Option func(*parseConfig)
+
+type parseConfig struct {
+	maxNameservers int
+	strict         bool
+	lenient        bool // set by ParseLenient
+}
+
+func newParseConfig(opts []Option) parseConfig {
+	var cfg parseConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithMaxNameservers sets the count of nameservers the resolver uses, e.g.
+// systemd-resolved uses more than 3, which libc does. The limit is advisory:
+// all nameservers are still parsed and kept, but TruncatedNameservers returns
+// the first n of them and Validate reports excess nameservers against n
+// instead of 3. n <= 0 means libc limit, which is the default.
+func WithMaxNameservers(n int) Option {
+	return func(cfg *parseConfig) {
+		cfg.maxNameservers = n
+	}
+}
+
+// WithStrict makes directives unknown to libc, e.g. misspelled ones, parse
+// errors instead of being ignored. Blank and comment lines are allowed, as
+// well as legacy directives kept in Extras.
+func WithStrict() Option {
+	return func(cfg *parseConfig) {
+		cfg.strict = true
+	}
+}
+
+// parse parses resolv.conf content and computes its hash. Content is scanned
+// once, all directives are collected in the same pass.
+func parse(resolv []byte, opts ...Option) (*File, error) {
+	return parseContent(resolv, newParseConfig(opts))
+}
+
+// parseContent is the same as parse, but takes resolved options. If lenient
+// is set, lines which can't be parsed are skipped and their errors are
+// collected into Warnings.
+func parseContent(resolv []byte, cfg parseConfig) (*File, error) {
+	f := &File{
+		Content:     resolv,
+		Hash:        hashBytes(resolv),
+		Options:     []string{},
+		OptionLines: map[string]int{},
+		Search:      []string{},
+		Sortlist:    []SortlistPair{},
+		Extras:      map[string][]string{},
+		lines:       []line{},
+	}
+
+	body := bytes.TrimPrefix(resolv, []byte(byteOrderMark))
+	f.bom = len(body) != len(resolv)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	// content is already in memory, so lines of any length are allowed, the
+	// size is limited by readers, see MaxFileSize
+	scanner.Buffer(nil, len(body)+1)
+	scanner.Split(scanLines)
+	for number := 1; scanner.Scan(); number++ {
+		l, fields := newLine(scanner.Text())
+		f.lines = append(f.lines, l)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch args := fields[1:]; l.key {
+		case nameserverKey:
+			if len(args) > 0 {
+				var nameserver Nameserver
+				if nameserver, err = nameserverFromArgs(number, args); err == nil {
+					f.serverDetails = append(f.serverDetails, nameserver)
+				}
+			}
+		case optionKey:
+			for _, option := range args {
+				if _, ok := f.OptionLines[optionName(option)]; ok {
+					f.repeatedOptions = append(f.repeatedOptions, option)
+				}
+				f.Options = addOptions(f.Options, f.OptionLines, number, []string{option})
+			}
+		case searchKey:
+			// libc skips directives without arguments
+			if len(args) > 0 {
+				f.Search = args
+				f.domainLast = false
+			}
+		case domainKey:
+			if len(args) > 0 {
+				f.Domain = args[0]
+				f.domainLast = true
+			}
+		case lookupKey, familyKey:
+			f.Extras[l.key] = args
+		case sortlistKey:
+			var sortlist []SortlistPair
+			if sortlist, err = addSortlist(f.Sortlist, number, args); err == nil {
+				f.Sortlist = sortlist
+			}
+		default:
+			if cfg.strict {
+				err = checkDirective(number, l)
+			}
+		}
+		switch {
+		case err == nil:
+		case cfg.lenient:
+			f.Warnings = append(f.Warnings, err)
+		default:
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	f.Nameservers = nameserverIPs(f.serverDetails)
+	if cfg.maxNameservers > 0 {
+		f.maxNameservers = cfg.maxNameservers
+	}
+	return f, nil
+}
+
+// scanLines is bufio.SplitFunc, which splits data into lines the same way as
+// splitLines does: "\r\n", "\r" and "\n" are line breaks.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i != -1 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// '\r', which might be followed by '\n'
+		switch {
+		case i+1 < len(data):
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		case atEOF:
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil // requesting more data to check the next byte
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,58 @@
+package resolvconf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// MaxFileSize is the maximum size of resolv.conf in bytes, which is read by
+// Get* and Parse* functions reading files and readers. Real files are a few
+// hundred bytes, the limit guards against allocating memory for a huge file,
+// e.g. when resolv.conf is symlinked to something else. It can be raised for
+// unusual deployments before reading any files.
+var MaxFileSize int64 = 64 << 10
+
+// ErrFileTooLarge is returned when content is larger than MaxFileSize.
+var ErrFileTooLarge = errors.New("resolv.conf is too large")
+
+// readLimited reads r till the end, failing if there is more than MaxFileSize
+// bytes.
+func readLimited(r io.Reader) ([]byte, error) {
+	limit := MaxFileSize
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: more than %v bytes", ErrFileTooLarge, limit)
+	}
+	return data, nil
+}
+
+// readFileLimited is the same as ioutil.ReadFile, but fails on files larger
+// than MaxFileSize.
+func readFileLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLimited(f)
+}
+
+// readFSLimited is the same as fs.ReadFile, but fails on files larger than
+// MaxFileSize.
+func readFSLimited(fsys fs.FS, path string) ([]byte, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLimited(f)
+}
@@ -0,0 +1,46 @@
+package resolvconf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry keeps named resolver profiles, e.g. "corp", "home" and "vpn", and
+// writes the chosen one to disk. Zero value is an empty registry, ready to
+// use. Registry is safe for concurrent use.
+type Registry struct {
+	mu    sync.Mutex
+	files map[string]*File
+}
+
+// Set stores a copy of f as the profile name, replacing the previous one.
+func (r *Registry) Set(name string, f *File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.files == nil {
+		r.files = make(map[string]*File)
+	}
+	r.files[name] = f.Clone()
+}
+
+// Get returns a copy of the profile name and reports whether it's there.
+func (r *Registry) Get(name string) (*File, bool) {
+	r.mu.Lock()
+	f, ok := r.files[name]
+	r.mu.Unlock()
+
+	return f.Clone(), ok
+}
+
+// ActivateTo atomically writes the profile name to path, see WriteFile.
+func (r *Registry) ActivateTo(name, path string) error {
+	f, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("activating %q: no such profile", name)
+	}
+	if err := f.WriteFile(path); err != nil {
+		return fmt.Errorf("activating %q: %w", name, err)
+	}
+	return nil
+}
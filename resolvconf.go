@@ -3,9 +3,17 @@ package resolvconf
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash"
+	"io"
+	"io/fs"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -15,13 +23,25 @@ const (
 	defaultPath = "/etc/resolv.conf"
 	// alternatePath is a path different from defaultPath, that may be used to resolve DNS. See Path().
 	alternatePath = "/run/systemd/resolve/resolv.conf"
-
-	commentMark = "#"
 )
 
+// commentMarks are the markers starting a comment in resolv.conf. libc accepts
+// only "#" and ";" at the line start, but they are also stripped after
+// whitespace anywhere in the line.
+var commentMarks = []string{"#", ";"}
+
+// StubResolverAddress is the address of systemd-resolved stub resolver, Path
+// switches to the systemd-resolved file only when it's the only nameserver.
+var StubResolverAddress = net.IPv4(127, 0, 0, 53)
+
+// readFile reads files for Path and Get* functions, tests replace it to avoid
+// touching the real filesystem.
+var readFile = readFileLimited
+
 var (
-	detectSystemdResolvConfOnce sync.Once
-	pathAfterSystemdDetection   = defaultPath
+	pathMu                    sync.Mutex
+	pathDetected              bool
+	pathAfterSystemdDetection = defaultPath
 )
 
 // Path returns the path to the resolv.conf file that libnetwork should use.
@@ -34,27 +54,77 @@ var (
 //
 // Errors are silenced as they will inevitably resurface at future open/read calls.
 //
+// Detection is done once, see ResetPathDetection to redo it.
+//
+// If RESOLVCONF_PATH environment variable is set, its value is returned
+// instead and detection is skipped.
+//
 // More information at https://www.freedesktop.org/software/systemd/man/systemd-resolved.service.html#/etc/resolv.conf
 func Path() string {
-	detectSystemdResolvConfOnce.Do(func() {
-		candidateResolvConf, err := ioutil.ReadFile(defaultPath)
-		if err != nil {
-			// silencing error as it will resurface at next calls trying to read defaultPath
-			return
-		}
-		ns, err := getNameservers(string(candidateResolvConf))
-		if err != nil {
-			// same as ignoring error upper
-			return
-		}
+	if path := os.Getenv(pathEnv); path != "" {
+		return path
+	}
 
-		if len(ns) == 1 && ns[0].IsLoopback() {
-			pathAfterSystemdDetection = alternatePath
-		}
-	})
+	pathMu.Lock()
+	defer pathMu.Unlock()
+
+	if !pathDetected {
+		pathAfterSystemdDetection = PathForFile(readFile)
+		pathDetected = true
+	}
 	return pathAfterSystemdDetection
 }
 
+// ResolvedPath returns the path returned by Path and its real location after
+// evaluating symlinks. On many systems /etc/resolv.conf is a symlink to
+// /run/systemd/resolve/stub-resolv.conf or similar file, which matters for
+// watching the file and writing it atomically: renaming over the symlink
+// replaces the symlink itself, not the file it points to.
+func ResolvedPath() (declared, target string, err error) {
+	declared = Path()
+	target, err = filepath.EvalSymlinks(declared)
+	if err != nil {
+		return declared, "", err
+	}
+	return declared, target, nil
+}
+
+// ResetPathDetection drops the path detected by Path, so the next call detects
+// it again. Useful for long-lived processes started before systemd-resolved.
+func ResetPathDetection() {
+	pathMu.Lock()
+	defer pathMu.Unlock()
+
+	pathDetected = false
+	pathAfterSystemdDetection = defaultPath
+}
+
+// PathForFile detects the path the same way as Path does, but reads files with
+// readFile and doesn't cache the result. If file at alternate path can't be
+// read, default one is returned.
+func PathForFile(readFile func(string) ([]byte, error)) string {
+	candidateResolvConf, err := readFile(defaultPath)
+	if err != nil {
+		// silencing error as it will resurface at next calls trying to read defaultPath
+		return defaultPath
+	}
+	candidate, err := parse(candidateResolvConf)
+	if err != nil {
+		// same as ignoring error upper
+		return defaultPath
+	}
+
+	ns := candidate.Nameservers
+	if len(ns) != 1 || !ns[0].Equal(StubResolverAddress) {
+		return defaultPath
+	}
+	if _, err := readFile(alternatePath); err != nil {
+		// systemd-resolved doesn't provide its resolv.conf, so there is
+		// nothing better than defaultPath
+		return defaultPath
+	}
+	return alternatePath
+}
 
 // File contains the resolv.conf content and its hash
 // todo: make https://linux.die.net/man/5/resolv.conf full spec-compilant
@@ -62,92 +132,677 @@ type File struct {
 	Content []byte
 	Hash    string
 
-	Nameservers []net.IP
-	Options     []string // todo: options are fixed, need to make options type and a few methods for it
+	Nameservers []net.IP       // see Nameservers2 for zones and ports
+	Options     []string       // raw options, see ParseOptions for typed representation
+	OptionLines map[string]int // 1-based line numbers of options, by option names
+	Search      []string
+	Domain      string
+	Sortlist    []SortlistPair
+
+	// Extras are arguments of legacy directives, which libc ignores, but BSD
+	// and older resolvers use: "lookup file bind" and "family inet6 inet4",
+	// mapped by directive. The last line of each directive wins, same as for
+	// search.
+	Extras map[string][]string
+
+	Source Source // which file the content was read from
+
+	// Warnings are errors of lines skipped by ParseLenient, always empty for
+	// files parsed in other ways.
+	Warnings []error
+
+	// path and fsys are where the file was read from, used by Reload. Path
+	// is empty for parsed content, nil fsys means the OS filesystem.
+	path string
+	fsys fs.FS
+	opts []Option // options the file was parsed with
+
+	// serverDetails are Nameservers with their zones, ports and lines, matched
+	// to Nameservers by ip, see servers.
+	serverDetails []Nameserver
+
+	// maxNameservers is the limit set by WithMaxNameservers, 0 if not set.
+	maxNameservers int
+
+	// lines is the layout of parsed content, used by Marshal to keep comments
+	// and ordering of the original file. Nil for files built from scratch.
+	lines []line
+
+	// repeatedOptions are options of parsed content, which set the option
+	// already set earlier. Parser keeps only the last of them in Options.
+	repeatedOptions []string
+
+	// domainLast is set when domain wins over search, as libc uses the one
+	// which is listed last. It's set by parser and cleared by mutators adding
+	// search domains, Marshal writes directives in the same order.
+	domainLast bool
+
+	// bom is set when content starts with UTF-8 byte order mark, which is
+	// ignored by parser.
+	bom bool
+
+	// dirty is set by mutators, when fields no longer describe Content.
+	dirty bool
+}
+
+// Clone returns a deep copy of f, so modifying the copy doesn't affect f.
+func (f *File) Clone() *File {
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+	c.Content = cloneBytes(f.Content)
+	if f.Nameservers != nil {
+		c.Nameservers = make([]net.IP, len(f.Nameservers))
+		for i, ip := range f.Nameservers {
+			c.Nameservers[i] = net.IP(cloneBytes(ip))
+		}
+	}
+	if f.serverDetails != nil {
+		c.serverDetails = make([]Nameserver, len(f.serverDetails))
+		for i, server := range f.serverDetails {
+			server.IP = net.IP(cloneBytes(server.IP))
+			c.serverDetails[i] = server
+		}
+	}
+	c.Options = cloneStrings(f.Options)
+	if f.OptionLines != nil {
+		c.OptionLines = make(map[string]int, len(f.OptionLines))
+		for name, line := range f.OptionLines {
+			c.OptionLines[name] = line
+		}
+	}
+	c.Search = cloneStrings(f.Search)
+	c.repeatedOptions = cloneStrings(f.repeatedOptions)
+	if f.Sortlist != nil {
+		c.Sortlist = make([]SortlistPair, len(f.Sortlist))
+		for i, pair := range f.Sortlist {
+			c.Sortlist[i] = SortlistPair{
+				Address: net.IP(cloneBytes(pair.Address)),
+				Netmask: net.IPMask(cloneBytes(pair.Netmask)),
+			}
+		}
+	}
+	if f.Extras != nil {
+		c.Extras = make(map[string][]string, len(f.Extras))
+		for key, args := range f.Extras {
+			c.Extras[key] = cloneStrings(args)
+		}
+	}
+	if f.Warnings != nil {
+		c.Warnings = append([]error{}, f.Warnings...)
+	}
+	if f.lines != nil {
+		c.lines = append([]line{}, f.lines...)
+	}
+	return &c
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+// Source describes where the content of File comes from.
+type Source int
+
+const (
+	SourceNone    Source = iota // not read from file, e.g. parsed from memory
+	SourceEtc                   // /etc/resolv.conf
+	SourceSystemd               // resolv.conf generated by systemd-resolved, see Path
+	SourceCustom                // any other file
+)
+
+func sourceOf(path string) Source {
+	switch filepath.Clean(path) {
+	case defaultPath:
+		return SourceEtc
+	case alternatePath:
+		return SourceSystemd
+	default:
+		return SourceCustom
+	}
+}
+
+func (s Source) String() string {
+	switch s {
+	case SourceNone:
+		return "none"
+	case SourceEtc:
+		return "etc"
+	case SourceSystemd:
+		return "systemd"
+	case SourceCustom:
+		return "custom"
+	default:
+		return "Source(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Nameserver is a single nameserver entry of resolv.conf.
+type Nameserver struct {
+	IP   net.IP
+	Zone string // ipv6 scope zone, e.g. "eth0" for fe80::1%eth0
+	Port string // optional port, e.g. "53" for 8.8.8.8:53
+	Line int    // 1-based line number in parsed content, 0 if not parsed
+	Raw  string // address as it's written in parsed content, e.g. "[::1]:53"
+}
+
+// nameserverIPs returns only ip addresses of servers.
+func nameserverIPs(servers []Nameserver) []net.IP {
+	ips := make([]net.IP, len(servers))
+	for i, server := range servers {
+		ips[i] = server.IP
+	}
+	return ips
+}
+
+// SortlistPair is a single address/netmask entry of sortlist directive.
+type SortlistPair struct {
+	Address net.IP
+	Netmask net.IPMask
 }
 
 // Get returns the contents of /etc/resolv.conf and its hash
+//
+// If Path detected systemd-resolved file, but it can't be read or parsed,
+// /etc/resolv.conf is used instead. Path set with RESOLVCONF_PATH environment
+// variable is used without fallback.
 func Get() (*File, error) {
-	return GetSpecific(Path())
+	path := Path()
+	if path == defaultPath || os.Getenv(pathEnv) != "" {
+		return GetSpecific(path)
+	}
+	return GetWithFallback(path, defaultPath)
+}
+
+// GetWithFallback tries paths in order and returns the first file which can be
+// read and parsed. If none of them can, errors of all paths are returned.
+func GetWithFallback(paths ...string) (*File, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no paths to read resolv.conf from")
+	}
+
+	errs := make([]error, 0, len(paths))
+	for _, path := range paths {
+		f, err := GetSpecific(path)
+		if err == nil {
+			return f, nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", path, err))
+	}
+	return nil, errors.Join(errs...)
 }
 
 // GetSpecific returns the contents of the user specified resolv.conf file and its hash
-func GetSpecific(path string) (*File, error) {
-	resolv, err := ioutil.ReadFile(path)
+//
+// Parsing can be tuned with opts, e.g. WithStrict. Without them the file is
+// parsed as libc would do it.
+func GetSpecific(path string, opts ...Option) (*File, error) {
+	resolv, err := readFile(path)
 	if err != nil {
 		return nil, err
 	}
-	hash, err := hashData(bytes.NewReader(resolv))
+	return parseFile(path, resolv, opts)
+}
+
+// GetFromFile is the same as GetSpecific, but reads already opened file from
+// its current offset till the end, so the same descriptor can be used to Stat
+// the file, avoiding races with replacing the file at path. It doesn't seek or
+// close file, this is left to the caller.
+func GetFromFile(file *os.File, opts ...Option) (*File, error) {
+	resolv, err := readLimited(file)
 	if err != nil {
 		return nil, err
 	}
+	return parseFile(file.Name(), resolv, opts)
+}
 
-	nameservers, err := getNameservers(string(resolv))
+// GetFS is the same as GetSpecific, but reads the file from fsys, e.g. embed.FS
+// or fstest.MapFS. Path follows fs.FS rules, so it's unrooted:
+// "etc/resolv.conf", not "/etc/resolv.conf".
+func GetFS(fsys fs.FS, path string, opts ...Option) (*File, error) {
+	resolv, err := readFSLimited(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFile(path, resolv, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	options := getOptions(string(resolv))
+	f.fsys = fsys
+	return f, nil
+}
 
-	return &File{
-		Content:     resolv,
-		Hash:        hash,
-		Nameservers: nameservers,
-		Options: options,
-	}, nil
+// parseFile parses content read from path and records its source and opts,
+// so Reload parses it the same way.
+func parseFile(path string, resolv []byte, opts []Option) (*File, error) {
+	f, err := parse(resolv, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Source = sourceOf(path)
+	f.path, f.opts = path, opts
+	return f, nil
 }
 
-const nameserverKey = "nameserver"
+// ErrNoPath is returned by Reload for files which weren't read from a path,
+// e.g. parsed with ParseReader or built from scratch.
+var ErrNoPath = errors.New("file wasn't read from a path")
 
-// getNameservers returns nameservers (if any) listed in /etc/resolv.conf
-func getNameservers(resolvConf string) ([]net.IP, error) {
-	nameservers := []net.IP{}
-	for i, line := range getLines(resolvConf, commentMark) {
-		if !strings.HasPrefix(line, nameserverKey) {
-			continue // skip if not nameserver
-		}
+// Reload reads the file again from the path it was read from and reports
+// whether its content changed. If it did, f is replaced in place with the new
+// content, dropping any modifications of f, otherwise f is left untouched.
+// Hash is recomputed with sha256, see GetSpecificWithHasher.
+func (f *File) Reload() (changed bool, err error) {
+	if f.path == "" {
+		return false, ErrNoPath
+	}
+
+	var resolv []byte
+	if f.fsys != nil {
+		resolv, err = readFSLimited(f.fsys, f.path)
+	} else {
+		resolv, err = readFile(f.path)
+	}
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(resolv, f.Content) {
+		return false, nil
+	}
+
+	reloaded, err := parseFile(f.path, resolv, f.opts)
+	if err != nil {
+		return false, err
+	}
+	reloaded.fsys = f.fsys
+	*f = *reloaded
+	return true, nil
+}
 
-		line := strings.TrimSpace(strings.TrimPrefix(line, nameserverKey))
-		ip := net.ParseIP(line)
-		if ip == nil {
-			return nil, fmt.Errorf("line %v: invalid ip address of nameserver: %q", i, line)
+// GetContext is the same as Get, but returns ctx.Err() if ctx is done before
+// the file is read.
+func GetContext(ctx context.Context) (*File, error) {
+	return GetSpecificContext(ctx, Path())
+}
+
+// GetSpecificContext is the same as GetSpecific, but returns ctx.Err() if ctx
+// is done before the file is read.
+//
+// Reads can't be interrupted, so the file is read in separate goroutine, which
+// is abandoned after ctx is done and finishes on its own.
+func GetSpecificContext(ctx context.Context, path string, opts ...Option) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		content []byte
+		err     error
+	}
+	done := make(chan result, 1) // buffered, so abandoned goroutine doesn't leak
+	go func() {
+		content, err := readFile(path)
+		done <- result{content, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
 		}
+		return parseFile(path, res.content, opts)
+	}
+}
 
-		nameservers = append(nameservers, ip)
+// GetSpecificWithHasher is the same as GetSpecific, but Hash is computed with
+// h instead of sha256 and prefixed with prefix, which should name the algorithm,
+// e.g. "md5:".
+func GetSpecificWithHasher(path string, h hash.Hash, prefix string, opts ...Option) (*File, error) {
+	f, err := GetSpecific(path, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return nameservers, nil
+	if f.Hash, err = hashDataWith(bytes.NewReader(f.Content), h, prefix); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-const optionKey = "option"
+// GetSpecificStrict is the same as GetSpecific, but returns an error for any
+// directive unknown to libc, e.g. misspelled one, instead of ignoring it.
+//
+// It's the same as GetSpecific(path, WithStrict()).
+func GetSpecificStrict(path string) (*File, error) {
+	return GetSpecific(path, WithStrict())
+}
+
+// checkDirective returns error if l at line number is unknown directive.
+// Blank and comment-only lines are allowed.
+func checkDirective(number int, l line) error {
+	if l.key == "" || indexOf(directiveOrder, l.key) != -1 {
+		return nil
+	}
+	return &ParseError{Line: number, Raw: l.raw, Kind: "directive", Err: errors.New("unknown directive")}
+}
+
+// ParseReader reads resolv.conf content from r and parses it the same way as
+// GetSpecific does.
+func ParseReader(r io.Reader, opts ...Option) (*File, error) {
+	resolv, err := readLimited(r)
+	if err != nil {
+		return nil, err
+	}
+	return parse(resolv, opts...)
+}
+
+// ParseBytes parses resolv.conf content the same way as GetSpecific does.
+// Content of returned File refers to b, so b must not be modified after call.
+func ParseBytes(b []byte, opts ...Option) (*File, error) {
+	return parse(b, opts...)
+}
+
+// ParseLenient parses resolv.conf content the same way as ParseBytes does, but
+// doesn't fail on lines which can't be parsed, e.g. nameserver with invalid
+// address. Such lines are skipped, like libc does, and their *ParseError are
+// collected into Warnings, so the rest of the file is still usable. With
+// WithStrict unknown directives are collected into Warnings as well.
+func ParseLenient(b []byte, opts ...Option) (*File, error) {
+	cfg := newParseConfig(opts)
+	cfg.lenient = true
+	return parseContent(b, cfg)
+}
+
+// ParseString parses resolv.conf content the same way as GetSpecific does.
+func ParseString(s string, opts ...Option) (*File, error) {
+	return parse([]byte(s), opts...)
+}
+
+const (
+	nameserverKey = "nameserver"
+	// maxNameservers is the maximum count of nameservers used by libc, all
+	// others are ignored. (MAXNS)
+	maxNameservers = 3
+)
 
-// GetOptions returns options (if any) listed in /etc/resolv.conf
-// If more than one options line is encountered, only the contents of the last
-// one is returned.
-func getOptions(resolvConf string) []string {
-	options := []string{}
-	for _, line := range getLines(resolvConf, commentMark) {
-		if !strings.HasPrefix(line, optionKey) {
-			continue // skip if not option
+// nameserverFromArgs parses arguments of nameserver directive at line number.
+// libc uses only the first argument, ignoring the rest.
+func nameserverFromArgs(number int, args []string) (Nameserver, error) {
+	nameserver, err := parseNameserver(args[0])
+	if err != nil {
+		return Nameserver{}, &ParseError{Line: number, Raw: args[0], Kind: nameserverKey, Err: err}
+	}
+
+	nameserver.Line, nameserver.Raw = number, args[0]
+	return nameserver, nil
+}
+
+// parseNameserver parses nameserver address, which is ip address with optional
+// ipv6 zone and optional port: "8.8.8.8", "8.8.8.8:53", "fe80::1%eth0",
+// "[2001:4860:4860::8888]:53".
+func parseNameserver(value string) (Nameserver, error) {
+	host, port := value, ""
+	// plain ipv6 address has more than one colon, so it can't have a port
+	// without brackets
+	if strings.HasPrefix(value, "[") || strings.Count(value, ":") == 1 {
+		var err error
+		if host, port, err = net.SplitHostPort(value); err != nil {
+			return Nameserver{}, errors.New("invalid ip address of nameserver")
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return Nameserver{}, errors.New("invalid port of nameserver")
 		}
+	}
+
+	address, zone := host, ""
+	if i := strings.Index(host, "%"); i != -1 {
+		address, zone = host[:i], host[i+1:]
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil || (zone != "" && ip.To4() != nil) {
+		return Nameserver{}, errors.New("invalid ip address of nameserver")
+	}
+
+	return Nameserver{IP: ip, Zone: zone, Port: port}, nil
+}
+
+const optionKey = "options"
 
-		line := strings.TrimSpace(strings.TrimPrefix(line, nameserverKey))
-		options = append(options, line)
+// addOptions adds arguments of options directive at line number to options
+// and stores their lines.
+func addOptions(options []string, lines map[string]int, number int, args []string) []string {
+	for _, option := range args {
+		options = setOption(options, option)
+		lines[optionName(option)] = number
 	}
 	return options
 }
 
+// setOption adds option to options, replacing the option with the same name if
+// it's already set. If there are several of them, the first one is replaced
+// and others are dropped.
+func setOption(options []string, option string) []string {
+	name := optionName(option)
+	for i, existing := range options {
+		if optionName(existing) == name {
+			options[i] = option
+			return append(options[:i+1], removeOption(options[i+1:], name)...)
+		}
+	}
+	return append(options, option)
+}
+
+// removeOption returns options without ones named name, reusing options.
+func removeOption(options []string, name string) []string {
+	kept := options[:0]
+	for _, option := range options {
+		if optionName(option) != name {
+			kept = append(kept, option)
+		}
+	}
+	return kept
+}
+
+// optionName returns the name of option, e.g. "ndots" for "ndots:5".
+func optionName(option string) string {
+	if i := strings.Index(option, ":"); i != -1 {
+		return option[:i]
+	}
+	return option
+}
+
+const (
+	searchKey = "search"
+	// maxSearch is the maximum count of search domains used by libc.
+	// (MAXDNSRCH)
+	maxSearch = 6
+)
+
+const domainKey = "domain"
+
+const (
+	sortlistKey = "sortlist"
+	// maxSortlist is the maximum count of sortlist pairs used by libc, all
+	// others are ignored. (MAXRESOLVSORT)
+	maxSortlist = 10
+)
+
+// addSortlist adds arguments of sortlist directive at line number to sortlist,
+// up to the maxSortlist.
+func addSortlist(sortlist []SortlistPair, number int, args []string) ([]SortlistPair, error) {
+	for _, field := range args {
+		if len(sortlist) >= maxSortlist {
+			return sortlist, nil
+		}
+
+		pair, err := parseSortlistPair(field)
+		if err != nil {
+			return nil, &ParseError{Line: number, Raw: field, Kind: sortlistKey, Err: err}
+		}
+		sortlist = append(sortlist, pair)
+	}
+	return sortlist, nil
+}
+
+// parseSortlistPair parses "address/netmask" or "address" entry. If netmask is
+// omitted, the natural (classful) netmask of address is used, as libc does.
+func parseSortlistPair(entry string) (SortlistPair, error) {
+	address, netmask := entry, ""
+	if i := strings.Index(entry, "/"); i != -1 {
+		address, netmask = entry[:i], entry[i+1:]
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return SortlistPair{}, errors.New("invalid address of sortlist entry")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if netmask == "" {
+		return SortlistPair{Address: ip, Netmask: naturalMask(ip)}, nil
+	}
+
+	mask := net.ParseIP(netmask)
+	if mask == nil {
+		return SortlistPair{}, errors.New("invalid netmask of sortlist entry")
+	}
+	if len(ip) == net.IPv4len {
+		mask = mask.To4()
+		if mask == nil {
+			return SortlistPair{}, errors.New("ipv6 netmask of ipv4 sortlist entry")
+		}
+	}
+	return SortlistPair{Address: ip, Netmask: net.IPMask(mask)}, nil
+}
+
+// naturalMask returns the classful netmask of ipv4 address, or host mask for
+// ipv6 address.
+func naturalMask(ip net.IP) net.IPMask {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)
+	}
+
+	switch {
+	case ip4[0] < 128: // class A
+		return net.CIDRMask(8, 8*net.IPv4len)
+	case ip4[0] < 192: // class B
+		return net.CIDRMask(16, 8*net.IPv4len)
+	default: // class C and others
+		return net.CIDRMask(24, 8*net.IPv4len)
+	}
+}
+
+// SortAddresses returns addrs ordered by sortlist the way libc orders
+// addresses of resolved hosts: addresses matching earlier sortlist entries go
+// first, addresses matching no entry go last. Addresses matching the same
+// entry keep their relative order. addrs is not modified.
+func (f *File) SortAddresses(addrs []net.IP) []net.IP {
+	ranks := make([]int, len(addrs))
+	for i, addr := range addrs {
+		ranks[i] = f.sortlistRank(addr)
+	}
+
+	order := make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ranks[order[i]] < ranks[order[j]] })
+
+	sorted := make([]net.IP, len(addrs))
+	for i, idx := range order {
+		sorted[i] = addrs[idx]
+	}
+	return sorted
+}
+
+// sortlistRank returns index of the first sortlist entry matching ip, or
+// length of sortlist if none matches.
+func (f *File) sortlistRank(ip net.IP) int {
+	for i, pair := range f.Sortlist {
+		mask := pair.Netmask
+		if mask == nil {
+			mask = naturalMask(pair.Address)
+		}
+		if network := pair.Address.Mask(mask); network != nil && network.Equal(ip.Mask(mask)) {
+			return i
+		}
+	}
+	return len(f.Sortlist)
+}
+
 // getLines parses input into lines and strips away comments and spaces.
-func getLines(input string, commentMarker string) []string {
-	lines := strings.Split(input, "\n")
+func getLines(input string, commentMarkers ...string) []string {
+	lines := splitLines(strings.TrimPrefix(input, byteOrderMark))
 	output := make([]string, 0, len(lines)) // hope that count of comments is 1 or 2 lines
 	for _, currentLine := range lines {
-		var commentIndex = strings.Index(currentLine, commentMarker)
-		line := currentLine
-		if commentIndex != -1 {
-			line = line[:commentIndex]
-		}
-
+		line, _ := splitComment(currentLine, commentMarkers...)
 		output = append(output, strings.TrimSpace(line))
 	}
 	return output
 }
+
+// splitLines splits input into lines, treating "\r\n", "\r" and "\n" as line
+// breaks, so files edited on other platforms are parsed the same way.
+func splitLines(input string) []string {
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	input = strings.ReplaceAll(input, "\r", "\n")
+	return strings.Split(input, "\n")
+}
+
+// splitComment splits line into its content and the comment (if any),
+// including the marker and whitespace before it. Comment starts with the first
+// of any commentMarkers, which is at the line start or follows whitespace, so
+// markers inside values (e.g. "1.1.1.1#note") don't truncate them.
+func splitComment(line string, commentMarkers ...string) (content, comment string) {
+	commentIndex := -1
+	for _, marker := range commentMarkers {
+		if i := commentStart(line, marker); i != -1 && (commentIndex == -1 || i < commentIndex) {
+			commentIndex = i
+		}
+	}
+	if commentIndex == -1 {
+		return line, ""
+	}
+
+	content = strings.TrimRight(line[:commentIndex], " \t")
+	return content, line[len(content):]
+}
+
+// commentStart returns index of the first marker in line, which is at the line
+// start or follows whitespace, or -1 if there is none.
+func commentStart(line, marker string) int {
+	if marker == "" {
+		return -1
+	}
+	for offset := 0; offset < len(line); {
+		i := strings.Index(line[offset:], marker)
+		if i == -1 {
+			return -1
+		}
+		i += offset
+		if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+			return i
+		}
+		offset = i + len(marker)
+	}
+	return -1
+}
@@ -1,3 +1,2964 @@
 package resolvconf
 
-// TODO:
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestGetOptions(t *testing.T) {
+	f, err := ParseString("options ndots:2 timeout:3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ndots:2", "timeout:3"}
+	if !reflect.DeepEqual(f.Options, want) {
+		t.Errorf("Options = %q, want %q", f.Options, want)
+	}
+}
+
+func TestGetOptionsMultipleLines(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []string
+	}{{
+		name:  "single line",
+		input: "options ndots:5 attempts:2 rotate\n",
+		want:  []string{"ndots:5", "attempts:2", "rotate"},
+	}, {
+		name:  "accumulated lines",
+		input: "options ndots:5\noptions rotate\n",
+		want:  []string{"ndots:5", "rotate"},
+	}, {
+		name:  "later value overrides",
+		input: "options ndots:5 rotate\noptions ndots:2\n",
+		want:  []string{"ndots:2", "rotate"},
+	}, {
+		name:  "tabs and spaces",
+		input: "options\tndots:5 \t attempts:2\t\trotate  \n",
+		want:  []string{"ndots:5", "attempts:2", "rotate"},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseString(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.Options, tt.want) {
+				t.Errorf("Options = %q, want %q", f.Options, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNameservers(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  Nameserver
+	}{
+		{input: "nameserver fe80::1%eth0", want: Nameserver{IP: net.ParseIP("fe80::1"), Zone: "eth0"}},
+		{input: "nameserver ::1", want: Nameserver{IP: net.ParseIP("::1")}},
+		{input: "nameserver 8.8.8.8:53", want: Nameserver{IP: net.ParseIP("8.8.8.8"), Port: "53"}},
+		{input: "nameserver [2001:4860:4860::8888]:53", want: Nameserver{IP: net.ParseIP("2001:4860:4860::8888"), Port: "53"}},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseNameservers(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || !got[0].IP.Equal(tt.want.IP) || got[0].Zone != tt.want.Zone || got[0].Port != tt.want.Port {
+				t.Errorf("parseNameservers() = %v, want [%v]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameserversRaw(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\nnameserver [::1]:53\nnameserver fe80::1%eth0\nnameserver 8.8.8.8:5353\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AddNameserver(net.ParseIP("9.9.9.9"))
+
+	want := []Nameserver{
+		{IP: net.ParseIP("1.1.1.1"), Line: 1, Raw: "1.1.1.1"},
+		{IP: net.ParseIP("::1"), Port: "53", Line: 2, Raw: "[::1]:53"},
+		{IP: net.ParseIP("fe80::1"), Zone: "eth0", Line: 3, Raw: "fe80::1%eth0"},
+		{IP: net.ParseIP("8.8.8.8"), Port: "5353", Line: 4, Raw: "8.8.8.8:5353"},
+		{IP: net.ParseIP("9.9.9.9")},
+	}
+	got := f.Nameservers2()
+	if len(got) != len(want) {
+		t.Fatalf("Nameservers2() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].IP.Equal(want[i].IP) || got[i].Zone != want[i].Zone || got[i].Port != want[i].Port ||
+			got[i].Line != want[i].Line || got[i].Raw != want[i].Raw {
+			t.Errorf("Nameservers2()[%v] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	got[0].Raw = "changed"
+	if f.serverDetails[0].Raw != "1.1.1.1" {
+		t.Error("modifying Nameservers2() result changed nameservers")
+	}
+}
+
+func TestNameserversResliced(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\nnameserver fe80::1%eth0\nnameserver 8.8.8.8:5353\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Nameservers = f.Nameservers[1:]
+	if want := "nameserver fe80::1%eth0\nnameserver 8.8.8.8:5353\n"; f.String() != want {
+		t.Errorf("Marshal() after re-slicing Nameservers = %q, want %q", f.String(), want)
+	}
+
+	f.Nameservers = []net.IP{f.Nameservers[1], net.ParseIP("9.9.9.9"), f.Nameservers[0]}
+	if want := "nameserver 8.8.8.8:5353\nnameserver 9.9.9.9\nnameserver fe80::1%eth0\n"; f.String() != want {
+		t.Errorf("Marshal() after reordering Nameservers = %q, want %q", f.String(), want)
+	}
+}
+
+func TestGetNameserversInvalidPort(t *testing.T) {
+	for _, input := range []string{
+		"nameserver 8.8.8.8:dns",
+		"nameserver 8.8.8.8:65536",
+		"nameserver [::1]:0",
+	} {
+		if _, err := parseNameservers(input); err == nil {
+			t.Errorf("parseNameservers(%q) expected error", input)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	f, err := ParseString(`# comment
+nameserver 1.1.1.1
+nameserver fe80::1%eth0
+nameserver [2001:4860:4860::8888]:53
+domain example.com
+search example.com corp.local
+sortlist 130.155.160.0/255.255.240.0 130.155.0.0
+options ndots:2 rotate
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseBytes(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got.Content, got.Hash, got.lines = f.Content, f.Hash, f.lines
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip mismatch:\ngot  %+v\nwant %+v", got, f)
+	}
+}
+
+func TestMarshalWithOptionsPerLine(t *testing.T) {
+	f := &File{
+		Nameservers: []net.IP{net.ParseIP("1.1.1.1")},
+		Options:     []string{"ndots:2", "rotate", "timeout:3"},
+	}
+
+	for _, tt := range []struct {
+		opts MarshalOptions
+		want string
+	}{
+		{opts: MarshalOptions{}, want: "nameserver 1.1.1.1\noptions ndots:2 rotate timeout:3\n"},
+		{opts: MarshalOptions{OnePerLine: true}, want: "nameserver 1.1.1.1\noptions ndots:2\noptions rotate\noptions timeout:3\n"},
+	} {
+		got, err := f.MarshalWith(tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("MarshalWith(%+v) = %q, want %q", tt.opts, got, tt.want)
+		}
+
+		parsed, err := ParseBytes(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed.Options, f.Options) {
+			t.Errorf("MarshalWith(%+v) options parsed back as %q, want %q", tt.opts, parsed.Options, f.Options)
+		}
+	}
+}
+
+func TestMarshalTo(t *testing.T) {
+	f, err := ParseString("# comment\nnameserver 1.1.1.1 # primary\nsearch example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AddNameserver(net.ParseIP("8.8.8.8"))
+
+	want, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := f.MarshalTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) || n != len(want) {
+		t.Errorf("MarshalTo() wrote %q (%v bytes), want %q (%v bytes)", buf.String(), n, want, len(want))
+	}
+
+	broken := &File{Nameservers: []net.IP{nil}}
+	buf.Reset()
+	if _, err := broken.MarshalTo(&buf); err == nil || buf.Len() != 0 {
+		t.Errorf("MarshalTo() of invalid file = %q, %v, want nothing written and error", buf.String(), err)
+	}
+}
+
+func TestMarshalRejectsUnsafeValues(t *testing.T) {
+	for _, f := range []*File{
+		{Domain: "x y"},
+		{Search: []string{"a.com\nnameserver 7.7.7.7"}},
+		{Search: []string{""}},
+		{Options: []string{"#ndots:2"}},
+		{Options: []string{";rotate"}},
+		{Extras: map[string][]string{lookupKey: {"file\tbind"}}},
+		{serverDetails: []Nameserver{{IP: net.ParseIP("fe80::1"), Zone: "eth0 #"}}, Nameservers: []net.IP{net.ParseIP("fe80::1")}},
+	} {
+		if content, err := f.Marshal(); err == nil {
+			t.Errorf("Marshal() of %+v = %q, want error", f, content)
+		}
+	}
+
+	if _, err := new(Builder).SetDomain("x y").Build(); err == nil {
+		t.Error(`Build() with SetDomain("x y") succeeded`)
+	}
+}
+
+func TestMarshalCommentMarkerInValue(t *testing.T) {
+	content := "search corp#1.local a;b\noptions ndots:2#x\n"
+	f, err := ParseString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AddNameserver(net.ParseIP("1.1.1.1"))
+
+	marshaled, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseBytes(marshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Search, []string{"corp#1.local", "a;b"}) || !reflect.DeepEqual(got.Options, []string{"ndots:2#x"}) {
+		t.Errorf("round trip of %q = Search %q, Options %q", marshaled, got.Search, got.Options)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	want, err := ParseString("nameserver 8.8.8.8\nsearch example.com\noptions ndots:2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := want.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got File
+	if err := got.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("UnmarshalText() = %+v, want %+v", &got, want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ParseString("nameserver 8.8.8.8\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "nameserver 8.8.8.8\n" {
+		t.Errorf("WriteFile() wrote %q", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("WriteFile() didn't preserve mode: %v, %v", fi.Mode(), err)
+	}
+}
+
+func TestWriteFileUnchanged(t *testing.T) {
+	const original = "nameserver\t1.1.1.1\r\noptions   rotate\r\n"
+	for _, tt := range []struct {
+		name   string
+		modify func(f *File)
+		want   string
+	}{
+		{name: "unchanged", modify: func(f *File) {}, want: original},
+		{
+			name:   "mutator",
+			modify: func(f *File) { f.AddNameserver(net.ParseIP("8.8.8.8")) },
+			want:   "nameserver\t1.1.1.1\nnameserver 8.8.8.8\noptions   rotate\n",
+		},
+		{
+			name: "no-op mutators",
+			modify: func(f *File) {
+				f.RemoveNameserver(net.ParseIP("9.9.9.9"))
+				f.DedupeNameservers()
+				_, _ = f.RemoveLoopbackNameservers()
+			},
+			want: original,
+		},
+		{
+			name:   "field",
+			modify: func(f *File) { f.Options = []string{"ndots:2"} },
+			want:   "nameserver\t1.1.1.1\noptions ndots:2\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "resolv.conf")
+			f, err := ParseString(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(f)
+			if err := f.WriteFile(path); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("WriteFile() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	unlock, err := lockFile(path + lockSuffix)
+	if errors.Is(err, ErrLockUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- FromNameservers([]net.IP{net.IPv4(10, 0, 0, 1)}, nil).WriteFileLocked(path)
+	}()
+
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("WriteFileLocked() = %v while the lock is held, want it blocked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file is written while the lock is held: %v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteFileLocked() didn't finish after the lock is released")
+	}
+	if f, err := GetSpecific(path); err != nil || len(f.Nameservers) != 1 {
+		t.Errorf("WriteFileLocked() wrote %v, %v", f, err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, err := ParseString("# generated\nnameserver 1.1.1.1\nsearch example.com\noptions ndots:2 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseString("nameserver   1.1.1.1 # primary\n\nsearch EXAMPLE.com\noptions rotate\noptions ndots:2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseString("nameserver 1.1.1.1\nsearch example.com\noptions ndots:3 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.Equal(b) {
+		t.Error("files with different layout must be equal")
+	}
+	if a.Equal(c) {
+		t.Error("files with different options must not be equal")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	f, err := FromConfig(Config{
+		Nameservers: []string{"1.1.1.1", "fe80::1%eth0", "[2001:db8::53]:5353"},
+		Search:      []string{"example.com", "corp.local"},
+		Options:     []string{"ndots:2", "rotate"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "nameserver 1.1.1.1\nnameserver fe80::1%eth0\nnameserver [2001:db8::53]:5353\nsearch example.com corp.local\noptions ndots:2 rotate\n"
+	if string(f.Content) != want || f.Hash != hashBytes(f.Content) {
+		t.Errorf("FromConfig() Content = %q, want %q", f.Content, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+		is   error
+	}{
+		{name: "invalid address", cfg: Config{Nameservers: []string{"1.1.1.x"}}},
+		{name: "too many nameservers", cfg: Config{Nameservers: []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "8.8.4.4"}}, is: ErrTooManyNameservers},
+		{name: "too many search domains", cfg: Config{Search: []string{"a", "b", "c", "d", "e", "f", "g"}}},
+		{name: "whitespace in search", cfg: Config{Search: []string{"example.com corp.local"}}},
+		{name: "comment in domain", cfg: Config{Domain: "#example.com"}},
+		{name: "empty option", cfg: Config{Options: []string{""}}},
+		{name: "invalid numeric option", cfg: Config{Options: []string{"ndots:x"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromConfig(tt.cfg)
+			if err == nil {
+				t.Fatal("FromConfig() succeeded")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("FromConfig() error = %v, want %v", err, tt.is)
+			}
+		})
+	}
+}
+
+func ExampleBuilder() {
+	f, err := new(Builder).
+		AddNameserver(net.ParseIP("8.8.8.8")).
+		AddNameserver(net.ParseIP("8.8.4.4")).
+		Build()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Print(f)
+	// Output:
+	// nameserver 8.8.8.8
+	// nameserver 8.8.4.4
+}
+
+func TestResolvesEquivalently(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{
+			name: "reordered without rotate",
+			a:    "nameserver 1.1.1.1\nnameserver 8.8.8.8\n",
+			b:    "nameserver 8.8.8.8\nnameserver 1.1.1.1\n",
+		},
+		{
+			name: "reordered with rotate",
+			a:    "nameserver 1.1.1.1\nnameserver 8.8.8.8\noptions rotate\n",
+			b:    "nameserver 8.8.8.8\nnameserver 1.1.1.1\noptions rotate\n",
+			want: true,
+		},
+		{
+			name: "rotate in one file only",
+			a:    "nameserver 1.1.1.1\noptions rotate\n",
+			b:    "nameserver 1.1.1.1\n",
+		},
+		{
+			name: "ignored fourth nameserver",
+			a:    "nameserver 1.1.1.1\nnameserver 1.0.0.1\nnameserver 8.8.8.8\nnameserver 8.8.4.4\n",
+			b:    "nameserver 1.1.1.1\nnameserver 1.0.0.1\nnameserver 8.8.8.8\n",
+			want: true,
+		},
+		{
+			name: "default and clamped options",
+			a:    "nameserver 1.1.1.1\noptions ndots:1 timeout:60 future-flag\n",
+			b:    "nameserver 1.1.1.1\noptions timeout:30\n",
+			want: true,
+		},
+		{
+			name: "domain overridden by search",
+			a:    "domain example.com\nsearch corp.local\n",
+			b:    "search CORP.local\n",
+			want: true,
+		},
+		{
+			name: "different search",
+			a:    "search corp.local\n",
+			b:    "search example.com\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseString(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ParseString(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.ResolvesEquivalently(b); got != tt.want {
+				t.Errorf("ResolvesEquivalently() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuilderExcessNameservers(t *testing.T) {
+	b := new(Builder)
+	for _, ip := range []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "8.8.4.4"} {
+		b.AddNameserver(net.ParseIP(ip))
+	}
+
+	if _, err := b.Build(); err == nil {
+		t.Error("Build() expected error for 4 nameservers")
+	}
+	if _, err := b.AllowExcessNameservers().Build(); err != nil {
+		t.Errorf("Build() with AllowExcessNameservers: %v", err)
+	}
+}
+
+func TestAddRemoveNameserver(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\nnameserver fe80::1%eth0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.AddNameserver(net.ParseIP("1.1.1.1"))
+	f.AddNameserver(net.ParseIP("8.8.8.8"))
+	if want := "nameserver 1.1.1.1\nnameserver fe80::1%eth0\nnameserver 8.8.8.8\n"; f.String() != want {
+		t.Errorf("after AddNameserver() got %q, want %q", f.String(), want)
+	}
+
+	if f.RemoveNameserver(net.ParseIP("9.9.9.9")) {
+		t.Error("RemoveNameserver() of missing ip reported true")
+	}
+	if !f.RemoveNameserver(net.ParseIP("1.1.1.1")) {
+		t.Error("RemoveNameserver() of existing ip reported false")
+	}
+	if want := "nameserver fe80::1%eth0\nnameserver 8.8.8.8\n"; f.String() != want {
+		t.Errorf("after RemoveNameserver() got %q, want %q", f.String(), want)
+	}
+}
+
+func TestReplaceNameservers(t *testing.T) {
+	f, err := ParseString("# keep me\nnameserver 1.1.1.1\nnameserver 1.0.0.1\nsearch example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.ReplaceNameservers([]net.IP{
+		net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4"), net.ParseIP("8.8.8.8"),
+		net.ParseIP("9.9.9.9"), net.ParseIP("149.112.112.112"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4"), net.ParseIP("9.9.9.9")}
+	if !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("after ReplaceNameservers() Nameservers = %v, want %v", f.Nameservers, want)
+	}
+
+	if err := f.ReplaceNameservers([]net.IP{net.ParseIP("1.1.1.1"), nil}); err == nil {
+		t.Error("ReplaceNameservers() with nil ip succeeded")
+	}
+	if !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("failed ReplaceNameservers() changed Nameservers to %v", f.Nameservers)
+	}
+
+	f.RemoveAllNameservers()
+	if len(f.Nameservers) != 0 || len(f.serverDetails) != 0 {
+		t.Errorf("after RemoveAllNameservers() Nameservers = %v, Nameservers2() = %v", f.Nameservers, f.Nameservers2())
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := f.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != "# keep me\nsearch example.com\n" {
+		t.Errorf("WriteFile() after RemoveAllNameservers() wrote %q, %v", got, err)
+	}
+}
+
+func TestTruncatedNameservers(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\nnameserver 1.0.0.1\nnameserver 8.8.8.8\nnameserver 8.8.4.4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.TruncatedNameservers(); len(got) != 3 || !got[2].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("TruncatedNameservers() = %v", got)
+	}
+	if len(f.Nameservers) != 4 {
+		t.Errorf("Nameservers must keep all entries, got %v", f.Nameservers)
+	}
+	if warnings := f.Validate(); len(warnings) != 1 || !errors.Is(warnings[0], ErrTooManyNameservers) {
+		t.Errorf("Validate() = %v", warnings)
+	}
+}
+
+func TestDedupeNameservers(t *testing.T) {
+	f, err := ParseString(`nameserver 1.1.1.1
+nameserver ::1
+nameserver ::ffff:1.1.1.1
+nameserver 8.8.8.8
+nameserver 0:0:0:0:0:0:0:1
+nameserver 1.1.1.1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.DedupeNameservers()
+	if want := "nameserver 1.1.1.1\nnameserver ::1\nnameserver 8.8.8.8\n"; f.String() != want {
+		t.Errorf("DedupeNameservers() got %q, want %q", f.String(), want)
+	}
+}
+
+func TestRemoveLoopbackNameservers(t *testing.T) {
+	f, err := ParseString("nameserver 127.0.0.53\nnameserver 8.8.8.8\nnameserver ::1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.RemoveLoopbackNameservers(); n != 2 || err != nil {
+		t.Errorf("RemoveLoopbackNameservers() = %v, %v", n, err)
+	}
+	if len(f.Nameservers) != 1 || !f.Nameservers[0].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("Nameservers = %v", f.Nameservers)
+	}
+
+	f, err = ParseString("nameserver 127.0.0.53\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.RemoveLoopbackNameservers(); n != 0 || !errors.Is(err, ErrOnlyLoopbackNameservers) {
+		t.Errorf("RemoveLoopbackNameservers() = %v, %v", n, err)
+	}
+	if len(f.Nameservers) != 1 {
+		t.Errorf("loopback-only nameservers must be kept, got %v", f.Nameservers)
+	}
+}
+
+func TestPrimaryNameserver(t *testing.T) {
+	for _, tt := range []struct {
+		content      string
+		want         net.IP
+		wantSkipping net.IP
+	}{
+		{content: ""},
+		{content: "nameserver 1.1.1.1\nnameserver 8.8.8.8\n", want: net.ParseIP("1.1.1.1"), wantSkipping: net.ParseIP("1.1.1.1")},
+		{content: "nameserver 127.0.0.53\nnameserver 8.8.8.8\n", want: net.ParseIP("127.0.0.53"), wantSkipping: net.ParseIP("8.8.8.8")},
+		{content: "nameserver ::1\n", want: net.ParseIP("::1")},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, ok := f.PrimaryNameserver(); !got.Equal(tt.want) || ok != (tt.want != nil) {
+			t.Errorf("%q: PrimaryNameserver() = %v, %v, want %v", tt.content, got, ok, tt.want)
+		}
+		if got, ok := f.PrimaryNameserverSkipLoopback(); !got.Equal(tt.wantSkipping) || ok != (tt.wantSkipping != nil) {
+			t.Errorf("%q: PrimaryNameserverSkipLoopback() = %v, %v, want %v", tt.content, got, ok, tt.wantSkipping)
+		}
+	}
+}
+
+func TestCanResolve(t *testing.T) {
+	for _, tt := range []struct {
+		content      string
+		want         bool
+		wantLoopback bool
+	}{
+		{content: ""},
+		{content: "nameserver 1.1.1.1\n", want: true, wantLoopback: true},
+		{content: "nameserver 127.0.0.53\n", wantLoopback: true},
+		{content: "nameserver ::1\nnameserver 8.8.8.8\n", want: true, wantLoopback: true},
+		{content: "nameserver 0.0.0.0\nnameserver 192.0.2.1\n"},
+		{content: "nameserver 127.0.0.1\nnameserver 127.0.0.2\nnameserver 127.0.0.3\nnameserver 8.8.8.8\n", wantLoopback: true},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.CanResolve(); got != tt.want {
+			t.Errorf("%q: CanResolve() = %v, want %v", tt.content, got, tt.want)
+		}
+		if got := f.CanResolveAllowLoopback(); got != tt.wantLoopback {
+			t.Errorf("%q: CanResolveAllowLoopback() = %v, want %v", tt.content, got, tt.wantLoopback)
+		}
+	}
+}
+
+func TestDetectStubLoop(t *testing.T) {
+	for content, want := range map[string]bool{
+		"nameserver 127.0.0.53\n":                        true,
+		"nameserver 127.0.0.53\nnameserver 127.0.0.53\n": true,
+		"nameserver 127.0.0.53\nnameserver 1.1.1.1\n":    false,
+		"nameserver 127.0.0.1\n":                         false,
+		"":                                               false,
+	} {
+		f, err := ParseString(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.DetectStubLoop(); got != want {
+			t.Errorf("%q: DetectStubLoop() = %v, want %v", content, got, want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base, err := ParseString(`nameserver 1.1.1.1
+nameserver 1.0.0.1
+domain base.local
+search base.local corp.local
+options ndots:5 rotate
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	override, err := ParseString(`nameserver 8.8.8.8
+nameserver 1.1.1.1
+search workload.local CORP.local
+options ndots:2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := Merge(base, override)
+	want := `nameserver 8.8.8.8
+nameserver 1.1.1.1
+nameserver 1.0.0.1
+domain base.local
+search workload.local CORP.local base.local
+options ndots:2 rotate
+`
+	if string(got.Content) != want {
+		t.Errorf("Merge() content = %q, want %q", got.Content, want)
+	}
+	if hash, _ := hashData(strings.NewReader(want)); got.Hash != hash {
+		t.Errorf("Merge() hash = %v, want %v", got.Hash, hash)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, err := ParseString("nameserver 1.1.1.1\nnameserver 8.8.8.8\nsearch a.local\noptions ndots:2 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := ParseString("nameserver 8.8.8.8\nnameserver 9.9.9.9\nsearch a.local\noptions ndots:5 edns0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := Diff(old, new)
+	want := Changes{
+		AddedNameservers:   []net.IP{net.ParseIP("9.9.9.9")},
+		RemovedNameservers: []net.IP{net.ParseIP("1.1.1.1")},
+		OptionsAdded:       []string{"edns0"},
+		OptionsRemoved:     []string{"rotate"},
+		OptionsChanged:     []string{"ndots:5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	if changes := Diff(old, old); !changes.IsZero() {
+		t.Errorf("Diff() of the same file = %+v", changes)
+	}
+}
+
+func TestDiffNameservers(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		old  string
+		new  string
+		want Changes
+	}{
+		{
+			name: "reordered",
+			old:  "nameserver 1.1.1.1\nnameserver 8.8.8.8\n",
+			new:  "nameserver 8.8.8.8\nnameserver 1.1.1.1\n",
+			want: Changes{NameserversReordered: true},
+		},
+		{
+			name: "duplicate removed",
+			old:  "nameserver 1.1.1.1\nnameserver 1.1.1.1\n",
+			new:  "nameserver 1.1.1.1\n",
+			want: Changes{RemovedNameservers: []net.IP{net.ParseIP("1.1.1.1")}},
+		},
+		{
+			name: "port changed",
+			old:  "nameserver 1.1.1.1\nnameserver 8.8.8.8\n",
+			new:  "nameserver 1.1.1.1\nnameserver 8.8.8.8:5353\n",
+			want: Changes{NameserversChanged: []Nameserver{{IP: net.ParseIP("8.8.8.8"), Port: "5353", Line: 2, Raw: "8.8.8.8:5353"}}},
+		},
+		{
+			name: "zone changed",
+			old:  "nameserver fe80::1%eth0\n",
+			new:  "nameserver fe80::1%eth1\n",
+			want: Changes{NameserversChanged: []Nameserver{{IP: net.ParseIP("fe80::1"), Zone: "eth1", Line: 1, Raw: "fe80::1%eth1"}}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := ParseString(tt.old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			new, err := ParseString(tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Diff(old, new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old, err := ParseString("# old\nnameserver 1.1.1.1\nnameserver 8.8.8.8\nsearch example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := ParseString("nameserver 8.8.8.8\nnameserver 1.1.1.1\nsearch example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `--- old
++++ new
+@@ -1,3 +1,3 @@
+-nameserver 1.1.1.1
+ nameserver 8.8.8.8
++nameserver 1.1.1.1
+ search example.com
+`
+	if got := UnifiedDiff(old, new); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if len(old.lines) == 0 {
+		t.Error("UnifiedDiff() modified its argument")
+	}
+
+	if got := UnifiedDiff(old, old.Clone()); got != "" {
+		t.Errorf("UnifiedDiff() of the same file = %q, want empty", got)
+	}
+	if got, want := UnifiedDiff(nil, new), "--- old\n+++ new\n@@ -0,0 +1,3 @@\n+nameserver 8.8.8.8\n+nameserver 1.1.1.1\n+search example.com\n"; got != want {
+		t.Errorf("UnifiedDiff() of nil file = %q, want %q", got, want)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	files, errs := Watch(ctx, path)
+
+	next := func() *File {
+		select {
+		case f := <-files:
+			return f
+		case err := <-errs:
+			t.Fatal(err)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for file")
+		}
+		return nil
+	}
+
+	if f := next(); !f.Nameservers[0].Equal(net.ParseIP("1.1.1.1")) {
+		t.Errorf("initial file = %v", f)
+	}
+
+	// replacing the file with atomic rename, watch must survive it
+	replacement, err := ParseString("nameserver 8.8.8.8\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replacement.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if f := next(); !f.Nameservers[0].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("replaced file = %v", f)
+	}
+
+	cancel()
+	for range files {
+	}
+}
+
+func TestGetSpecificCached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer InvalidateCache()
+
+	first, err := GetSpecificCached(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Nameservers[0][15] = 2 // must not corrupt the cache
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := GetSpecificCached(path, time.Hour)
+			if err != nil || !f.Nameservers[0].Equal(net.ParseIP("1.1.1.1")) {
+				t.Errorf("GetSpecificCached() = %v, %v", f, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	InvalidateCache()
+	if f, err := GetSpecificCached(path, time.Hour); err != nil || !f.Nameservers[0].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("GetSpecificCached() after InvalidateCache() = %v, %v", f, err)
+	}
+}
+
+func TestGetSpecificContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := GetSpecificContext(ctx, "/nonexistent/resolv.conf"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetSpecificContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPathForFile(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		content   string
+		err       error
+		noSystemd bool
+		want      string
+	}{
+		{name: "systemd stub", content: "nameserver 127.0.0.53\n", want: alternatePath},
+		{name: "custom local resolver", content: "nameserver 127.0.0.1\n", want: defaultPath},
+		{name: "systemd file missing", content: "nameserver 127.0.0.53\n", noSystemd: true, want: defaultPath},
+		{name: "regular", content: "nameserver 8.8.8.8\n", want: defaultPath},
+		{name: "unreadable", err: os.ErrNotExist, want: defaultPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PathForFile(func(path string) ([]byte, error) {
+				if path == alternatePath {
+					if tt.noSystemd {
+						return nil, os.ErrNotExist
+					}
+					return []byte("nameserver 8.8.8.8\n"), nil
+				}
+				return []byte(tt.content), tt.err
+			})
+			if got != tt.want {
+				t.Errorf("PathForFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("# skipped\nnameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(int64(len("# skipped\n")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := GetFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Content) != "nameserver 1.1.1.1\n" || f.Hash != hashBytes(f.Content) {
+		t.Errorf("GetFromFile() Content = %q, Hash = %v", f.Content, f.Hash)
+	}
+	if _, err := file.Stat(); err != nil {
+		t.Errorf("GetFromFile() closed the file: %v", err)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	defer func(orig int64) { MaxFileSize = orig }(MaxFileSize)
+	MaxFileSize = 32
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\nnameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSpecific(path); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("GetSpecific() of large file error = %v, want ErrFileTooLarge", err)
+	}
+	if _, err := ParseReader(strings.NewReader(strings.Repeat("#", 33))); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("ParseReader() of large content error = %v, want ErrFileTooLarge", err)
+	}
+	if _, err := ParseReader(strings.NewReader(strings.Repeat("#", 32))); err != nil {
+		t.Errorf("ParseReader() of content at the limit error = %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := GetSpecific(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed, err := f.Reload(); err != nil || changed {
+		t.Errorf("Reload() of unchanged file = %v, %v, want false, nil", changed, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldHash := f.Hash
+	if changed, err := f.Reload(); err != nil || !changed {
+		t.Errorf("Reload() of changed file = %v, %v, want true, nil", changed, err)
+	}
+	if want := []net.IP{net.ParseIP("8.8.8.8")}; !reflect.DeepEqual(f.Nameservers, want) || f.Hash == oldHash {
+		t.Errorf("after Reload() Nameservers = %v, Hash = %v", f.Nameservers, f.Hash)
+	}
+
+	parsed, err := ParseString("nameserver 1.1.1.1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.Reload(); !errors.Is(err, ErrNoPath) {
+		t.Errorf("Reload() of parsed file error = %v, want ErrNoPath", err)
+	}
+}
+
+func TestReloadFS(t *testing.T) {
+	fsys := fstest.MapFS{"resolv.conf": {Data: []byte("nameserver 1.1.1.1\n")}}
+	f, err := GetFS(fsys, "resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["resolv.conf"] = &fstest.MapFile{Data: []byte("nameserver 8.8.8.8\n")}
+	if changed, err := f.Reload(); err != nil || !changed {
+		t.Fatalf("Reload() = %v, %v, want true, nil", changed, err)
+	}
+	if want := []net.IP{net.ParseIP("8.8.8.8")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("after Reload() Nameservers = %v, want %v", f.Nameservers, want)
+	}
+}
+
+func TestGetFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/resolv.conf": {Data: []byte("nameserver 1.1.1.1\n")},
+	}
+
+	f, err := GetFS(fsys, "etc/resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.ParseIP("1.1.1.1")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+
+	if _, err := GetFS(fsys, "etc/missing.conf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetFS() of missing file error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestGetWithInjectedReadFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		strings.TrimPrefix(defaultPath, "/"):   {Data: []byte("nameserver 127.0.0.53\n")},
+		strings.TrimPrefix(alternatePath, "/"): {Data: []byte("nameserver 8.8.8.8\n")},
+	}
+	defer func(orig func(string) ([]byte, error)) {
+		readFile = orig
+		ResetPathDetection()
+	}(readFile)
+	readFile = func(path string) ([]byte, error) {
+		return fs.ReadFile(fsys, strings.TrimPrefix(path, "/"))
+	}
+	ResetPathDetection()
+
+	f, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Source != SourceSystemd {
+		t.Errorf("Source = %v, want %v", f.Source, SourceSystemd)
+	}
+	if want := []net.IP{net.ParseIP("8.8.8.8")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+}
+
+func TestPathEnv(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(custom, []byte("nameserver 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		strings.TrimPrefix(defaultPath, "/"):   {Data: []byte("nameserver 127.0.0.53\n")},
+		strings.TrimPrefix(alternatePath, "/"): {Data: []byte("nameserver 8.8.8.8\n")},
+	}
+	defer func(orig func(string) ([]byte, error)) {
+		readFile = orig
+		ResetPathDetection()
+	}(readFile)
+	readFile = func(path string) ([]byte, error) {
+		if path == custom {
+			return ioutil.ReadFile(path)
+		}
+		return fs.ReadFile(fsys, strings.TrimPrefix(path, "/"))
+	}
+	ResetPathDetection()
+
+	t.Setenv(pathEnv, custom)
+	if got := Path(); got != custom {
+		t.Errorf("Path() with %v set = %v, want %v", pathEnv, got, custom)
+	}
+	f, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.ParseIP("10.0.0.1")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Get() with %v set Nameservers = %v, want %v", pathEnv, f.Nameservers, want)
+	}
+
+	t.Setenv(pathEnv, "")
+	if got := Path(); got != alternatePath {
+		t.Errorf("Path() with %v unset = %v, want %v", pathEnv, got, alternatePath)
+	}
+}
+
+func TestResolvedPath(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "stub-resolv.conf")
+	if err := ioutil.WriteFile(real, []byte("nameserver 127.0.0.53\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "resolv.conf")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skip(err)
+	}
+	want, err := filepath.EvalSymlinks(real) // temp dir itself can be behind a symlink
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(pathEnv, link)
+	declared, target, err := ResolvedPath()
+	if err != nil || declared != link || target != want {
+		t.Errorf("ResolvedPath() = %v, %v, %v, want %v, %v", declared, target, err, link, want)
+	}
+
+	if err := os.Remove(real); err != nil {
+		t.Fatal(err)
+	}
+	declared, target, err = ResolvedPath()
+	if err == nil || declared != link || target != "" {
+		t.Errorf("ResolvedPath() of dangling link = %v, %q, %v, want error", declared, target, err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv(localDomainEnv, "env.local  other.local")
+	t.Setenv(resOptionsEnv, "ndots:3 edns0")
+
+	f, err := ParseString("search file.local\noptions ndots:1 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.applyEnv(os.LookupEnv)
+
+	if want := []string{"env.local", "other.local"}; !reflect.DeepEqual(f.Search, want) {
+		t.Errorf("Search = %q, want %q", f.Search, want)
+	}
+	if want := []string{"ndots:3", "rotate", "edns0"}; !reflect.DeepEqual(f.Options, want) {
+		t.Errorf("Options = %q, want %q", f.Options, want)
+	}
+}
+
+func TestApplyEnvOverridesDomain(t *testing.T) {
+	f, err := ParseString("search file.local\ndomain example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.applyEnv(func(name string) (string, bool) {
+		return "env.local", name == localDomainEnv
+	})
+
+	if got := f.QualifyName("foo"); got[0] != "foo.env.local." {
+		t.Errorf("QualifyName() = %q, want LOCALDOMAIN used", got)
+	}
+	if want := "search env.local\n"; !strings.HasSuffix(f.String(), want) {
+		t.Errorf("Marshal() = %q, want search written last", f.String())
+	}
+}
+
+func TestMarshalKeepsLayout(t *testing.T) {
+	f, err := ParseString(`# managed by foo
+
+nameserver 1.1.1.1 # cloudflare
+nameserver	8.8.8.8   # google
+unknown directive
+options ndots:2 # tuned
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.RemoveNameserver(net.ParseIP("1.1.1.1"))
+	f.AddNameserver(net.ParseIP("9.9.9.9"))
+	f.Options = []string{"ndots:5"}
+	f.Search = []string{"example.com"}
+
+	want := `# managed by foo
+
+nameserver	8.8.8.8   # google
+nameserver 9.9.9.9
+unknown directive
+options ndots:5 # tuned
+search example.com
+`
+	if got := f.String(); got != want {
+		t.Errorf("Marshal() = %q, want %q", got, want)
+	}
+}
+
+func TestGetSpecificStrict(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.conf")
+	if err := ioutil.WriteFile(valid, []byte("# comment\n\nnameserver 1.1.1.1\noptions rotate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	typo := filepath.Join(dir, "typo.conf")
+	if err := ioutil.WriteFile(typo, []byte("nameserver 1.1.1.1\nnameserevr 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetSpecificStrict(valid); err != nil {
+		t.Errorf("GetSpecificStrict(valid) = %v", err)
+	}
+	if _, err := GetSpecificStrict(typo); err == nil || !strings.Contains(err.Error(), "nameserevr") {
+		t.Errorf("GetSpecificStrict(typo) = %v, want unknown directive error", err)
+	}
+	if _, err := GetSpecific(typo); err != nil {
+		t.Errorf("GetSpecific(typo) = %v, lenient mode must ignore unknown directives", err)
+	}
+}
+
+func TestParseOptionsPlumbing(t *testing.T) {
+	content := "nameserver 10.0.0.1\nnameserver 10.0.0.2\nnameserver 10.0.0.3\nnameserver 10.0.0.4\nnameserver 10.0.0.5\n"
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := GetSpecific(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := f.Validate(); len(f.Nameservers) != 5 || len(errs) != 1 || errs[0].(*ValidationError).Value != "10.0.0.4" {
+		t.Errorf("default GetSpecific() = %v nameservers, Validate() = %v", len(f.Nameservers), errs)
+	}
+
+	f, err = GetSpecific(path, WithMaxNameservers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := f.Validate(); len(f.Nameservers) != 5 || len(errs) != 1 || errs[0].(*ValidationError).Value != "10.0.0.5" {
+		t.Errorf("GetSpecific(WithMaxNameservers(4)) = %v nameservers, Validate() = %v", len(f.Nameservers), errs)
+	}
+	if got := f.TruncatedNameservers(); len(got) != 4 {
+		t.Errorf("TruncatedNameservers() = %v, want first 4", got)
+	}
+	if all, err := ParseString(content, WithMaxNameservers(5)); err != nil {
+		t.Error(err)
+	} else if errs := all.Validate(); errs != nil {
+		t.Errorf("Validate() with 5 of 5 nameservers = %v", errs)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content+"nameservr 10.0.0.6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := f.Reload(); err != nil || !changed || len(f.TruncatedNameservers()) != 4 {
+		t.Errorf("Reload() = %v, %v with %v nameservers used, want options kept", changed, err, len(f.TruncatedNameservers()))
+	}
+
+	f, err = GetSpecific(path, WithMaxNameservers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetOption("rotate", "")
+	if err := f.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := GetSpecific(path); err != nil || len(written.Nameservers) != 5 {
+		t.Errorf("written file = %v, %v, want all nameservers kept", written, err)
+	}
+	var parseErr *ParseError
+	if _, err := GetSpecific(path, WithStrict(), WithMaxNameservers(2)); !errors.As(err, &parseErr) || parseErr.Line != 6 {
+		t.Errorf("GetSpecific(WithStrict()) error = %v, want error at line 6", err)
+	}
+	if _, err := ParseString("nameservr 10.0.0.6\n", WithStrict()); err == nil {
+		t.Error("ParseString(WithStrict()) of unknown directive succeeded")
+	}
+}
+
+func TestParseOptionsEntryPoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 10.0.0.1\nnameserver 10.0.0.2\nnameservr 10.0.0.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetSpecificContext(context.Background(), path, WithStrict()); err == nil {
+		t.Error("GetSpecificContext(WithStrict()) of unknown directive succeeded")
+	}
+	if _, err := GetSpecificWithHasher(path, md5.New(), "md5:", WithStrict()); err == nil {
+		t.Error("GetSpecificWithHasher(WithStrict()) of unknown directive succeeded")
+	}
+
+	defer InvalidateCache()
+	f, err := GetSpecificCached(path, time.Hour, WithMaxNameservers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.TruncatedNameservers(); len(got) != 1 {
+		t.Errorf("GetSpecificCached(WithMaxNameservers(1)) uses %v nameservers, want 1", got)
+	}
+	if f, err := GetSpecificCached(path, time.Hour); err != nil || len(f.TruncatedNameservers()) != 2 {
+		t.Errorf("GetSpecificCached() without options = %v, %v, want file parsed again", f, err)
+	}
+	if _, err := GetSpecificCached(path, time.Hour, WithStrict()); err == nil {
+		t.Error("GetSpecificCached(WithStrict()) of unknown directive succeeded")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := ParseString("# comment\nnameserver 1.1.1.1\nnameserver 1.1.1.x\n")
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseString() error = %v, want *ParseError", err)
+	}
+	if parseErr.Line != 3 || parseErr.Raw != "1.1.1.x" || parseErr.Kind != nameserverKey {
+		t.Errorf("ParseError = %+v", parseErr)
+	}
+	if want := `line 3: invalid ip address of nameserver: "1.1.1.x"`; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	content := []byte("nameserver 1.1.1.x\nnameserver 8.8.8.8\nsortlist 10.0.0.0/bad\nsearch example.com\noptions rotate\n")
+	if _, err := ParseBytes(content); err == nil {
+		t.Fatal("ParseBytes() of invalid content succeeded")
+	}
+
+	f, err := ParseLenient(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.ParseIP("8.8.8.8")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+	if len(f.Sortlist) != 0 || !reflect.DeepEqual(f.Search, []string{"example.com"}) || !reflect.DeepEqual(f.Options, []string{"rotate"}) {
+		t.Errorf("Sortlist = %v, Search = %q, Options = %q", f.Sortlist, f.Search, f.Options)
+	}
+
+	var lines []int
+	for _, warning := range f.Warnings {
+		var parseErr *ParseError
+		if !errors.As(warning, &parseErr) {
+			t.Fatalf("Warnings contain %T, want *ParseError", warning)
+		}
+		lines = append(lines, parseErr.Line)
+	}
+	if want := []int{1, 3}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Warnings are at lines %v, want %v", lines, want)
+	}
+
+	f, err = ParseLenient([]byte("nameservr 1.1.1.1\nnameserver 1.1.1.x\nnameserver 8.8.8.8\n"), WithStrict())
+	if err != nil {
+		t.Fatalf("ParseLenient(WithStrict()) = %v, want unknown directive in Warnings", err)
+	}
+	if len(f.Warnings) != 2 || !strings.Contains(f.Warnings[0].Error(), "nameservr") || len(f.Nameservers) != 1 {
+		t.Errorf("ParseLenient(WithStrict()) Warnings = %v, Nameservers = %v", f.Warnings, f.Nameservers)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f := &File{
+		Domain:  "example.com",
+		Search:  []string{"a.local", "b.local", "c.local", "d.local", "e.local", "f.local", "g.local"},
+		Options: []string{"ndots:16", "rotate", "rotate"},
+	}
+
+	var codes []string
+	for _, err := range f.Validate() {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Validate() returned %T, want *ValidationError", err)
+		}
+		codes = append(codes, validationErr.Code)
+	}
+
+	want := []string{CodeTooManySearchDomains, CodeDomainAndSearch, CodeNdotsTooLarge, CodeDuplicateOption}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("Validate() codes = %q, want %q", codes, want)
+	}
+}
+
+func TestValidateParsedDuplicateOptions(t *testing.T) {
+	f, err := ParseString("options ndots:2 ndots:5 rotate\noptions ndots:3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	for _, err := range f.Validate() {
+		if !errors.Is(err, &ValidationError{Code: CodeDuplicateOption}) {
+			t.Errorf("Validate() = %v, want only duplicate options", err)
+		}
+		values = append(values, err.(*ValidationError).Value)
+	}
+	if want := []string{"ndots:5", "ndots:3"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Validate() duplicate options = %q, want %q", values, want)
+	}
+
+	f.RemoveNameserver(net.ParseIP("9.9.9.9"))
+	if errs := f.Validate(); len(errs) != 2 {
+		t.Errorf("Validate() after no-op RemoveNameserver() = %v, want duplicates still reported", errs)
+	}
+
+	f.SetOption("rotate", "")
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() after options are rendered again = %v", errs)
+	}
+}
+
+func TestDedupeSearch(t *testing.T) {
+	f, err := ParseString("search corp.local example.com CORP.LOCAL Example.Com lab.local\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := f.Validate()
+	if len(errs) != 2 || !errors.Is(errs[0], &ValidationError{Code: CodeDuplicateSearch}) {
+		t.Errorf("Validate() = %v, want 2 duplicate search domains", errs)
+	}
+
+	if removed := f.DedupeSearch(); removed != 2 {
+		t.Errorf("DedupeSearch() = %v, want 2", removed)
+	}
+	if want := []string{"corp.local", "example.com", "lab.local"}; !reflect.DeepEqual(f.Search, want) {
+		t.Errorf("Search = %q, want %q", f.Search, want)
+	}
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() after DedupeSearch() = %v", errs)
+	}
+}
+
+func TestEnsureSearch(t *testing.T) {
+	f, err := ParseString("search corp.local\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added, err := f.EnsureSearch("CORP.local"); err != nil || added {
+		t.Errorf("EnsureSearch() of present domain = %v, %v, want false, nil", added, err)
+	}
+	if added, err := f.EnsureSearch("example.com"); err != nil || !added {
+		t.Errorf("EnsureSearch() of absent domain = %v, %v, want true, nil", added, err)
+	}
+	if added, err := f.EnsureSearch("example.com"); err != nil || added {
+		t.Errorf("repeated EnsureSearch() = %v, %v, want false, nil", added, err)
+	}
+	if want := []string{"corp.local", "example.com"}; !reflect.DeepEqual(f.Search, want) {
+		t.Errorf("Search = %q, want %q", f.Search, want)
+	}
+
+	full := &File{Search: []string{"a.local", "b.local", "c.local", "d.local", "e.local", "f.local"}}
+	added, err := full.EnsureSearch("g.local")
+	if added || !errors.Is(err, &ValidationError{Code: CodeTooManySearchDomains}) {
+		t.Errorf("EnsureSearch() at limit = %v, %v, want false, too many search domains", added, err)
+	}
+	if len(full.Search) != maxSearch {
+		t.Errorf("EnsureSearch() at limit changed Search to %q", full.Search)
+	}
+	if added, err := full.EnsureSearch("A.local"); err != nil || added {
+		t.Errorf("EnsureSearch() of present domain at limit = %v, %v, want false, nil", added, err)
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	f := &File{Domain: "bücher.example", Search: []string{"münchen.example", "corp.local", "_sip.lab.local"}}
+	if err := f.ToASCII(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Domain != "xn--bcher-kva.example" {
+		t.Errorf("Domain = %q, want xn--bcher-kva.example", f.Domain)
+	}
+	if want := []string{"xn--mnchen-3ya.example", "corp.local", "_sip.lab.local"}; !reflect.DeepEqual(f.Search, want) {
+		t.Errorf("Search = %q, want %q", f.Search, want)
+	}
+
+	invalid := &File{Search: []string{"corp.local", "a\u200db.example"}}
+	err := invalid.ToASCII()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", "a\u200db.example")) {
+		t.Errorf("ToASCII() of invalid domain error = %v, want error naming the domain", err)
+	}
+	if want := []string{"corp.local", "a\u200db.example"}; !reflect.DeepEqual(invalid.Search, want) {
+		t.Errorf("ToASCII() modified Search on error: %q", invalid.Search)
+	}
+}
+
+func TestEntryLines(t *testing.T) {
+	f, err := ParseString(`# header
+
+nameserver 1.1.1.1
+# backup
+nameserver 8.8.8.8
+options ndots:2
+options rotate ndots:3
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.serverDetails[0].Line != 3 || f.serverDetails[1].Line != 5 {
+		t.Errorf("nameserver lines = %v, %v, want 3, 5", f.serverDetails[0].Line, f.serverDetails[1].Line)
+	}
+	if want := map[string]int{"ndots": 7, "rotate": 7}; !reflect.DeepEqual(f.OptionLines, want) {
+		t.Errorf("OptionLines = %v, want %v", f.OptionLines, want)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	for _, input := range []string{
+		"nameserver 1.1.1.1\r\nsearch example.com\r\n",
+		"nameserver 1.1.1.1\rsearch example.com\r",
+	} {
+		f, err := ParseString(input)
+		if err != nil {
+			t.Fatalf("ParseString(%q) = %v", input, err)
+		}
+		if len(f.Nameservers) != 1 || !f.Nameservers[0].Equal(net.ParseIP("1.1.1.1")) {
+			t.Errorf("ParseString(%q) nameservers = %v", input, f.Nameservers)
+		}
+		if !reflect.DeepEqual(f.Search, []string{"example.com"}) {
+			t.Errorf("ParseString(%q) search = %q", input, f.Search)
+		}
+	}
+}
+
+func TestDirectiveKeywordBoundaries(t *testing.T) {
+	f, err := ParseString(`nameserverfoo 1.1.1.1
+searching 1.2.3.4
+domainfoo example.com
+optionsx rotate
+search   
+nameserver 8.8.8.8
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Nameservers) != 1 || !f.Nameservers[0].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("Nameservers = %v", f.Nameservers)
+	}
+	if len(f.Search) != 0 || f.Domain != "" || len(f.Options) != 0 {
+		t.Errorf("prefixed keywords must not match: search %q, domain %q, options %q", f.Search, f.Domain, f.Options)
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	const content = "\ufeffnameserver 1.1.1.1\nsearch example.com\n"
+	f, err := ParseString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []net.IP{net.ParseIP("1.1.1.1")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+	if errs := f.Validate(); len(errs) != 1 || !errors.Is(errs[0], &ValidationError{Code: CodeByteOrderMark}) {
+		t.Errorf("Validate() = %v, want byte order mark warning", errs)
+	}
+
+	f.AddNameserver(net.ParseIP("8.8.8.8"))
+	if got, want := f.String(), "nameserver 1.1.1.1\nnameserver 8.8.8.8\nsearch example.com\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	servers, err := parseNameservers(content)
+	if err != nil || len(servers) != 1 {
+		t.Errorf("parseNameservers() = %v, %v, want 1 nameserver", servers, err)
+	}
+}
+
+func TestCommentMarkers(t *testing.T) {
+	f, err := ParseString(`# hash comment
+; semicolon comment
+nameserver 1.1.1.1 ; primary
+nameserver 8.8.8.8 # secondary
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")}
+	if !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+}
+
+func TestNameserverInlineComment(t *testing.T) {
+	for _, input := range []string{
+		"nameserver 1.1.1.1 # note\n",
+		"nameserver 1.1.1.1   # cloudflare\n",
+		"nameserver 1.1.1.1\t; note\n",
+	} {
+		want := []net.IP{net.ParseIP("1.1.1.1")}
+
+		f, err := ParseString(input)
+		if err != nil {
+			t.Errorf("ParseString(%q) error: %v", input, err)
+			continue
+		}
+		if !reflect.DeepEqual(f.Nameservers, want) {
+			t.Errorf("ParseString(%q) Nameservers = %v, want %v", input, f.Nameservers, want)
+		}
+
+		servers, err := parseNameservers(input)
+		if err != nil {
+			t.Errorf("parseNameservers(%q) error: %v", input, err)
+			continue
+		}
+		if got := nameserverIPs(servers); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseNameservers(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestCommentMarkerNeedsWhitespace(t *testing.T) {
+	for _, input := range []string{"nameserver 1.1.1.1#note\n", "nameserver 1.1.1.1;note\n"} {
+		if _, err := ParseString(input); err == nil {
+			t.Errorf("ParseString(%q) succeeded, want malformed nameserver", input)
+		}
+		if _, err := parseNameservers(input); err == nil {
+			t.Errorf("parseNameservers(%q) succeeded, want malformed nameserver", input)
+		}
+	}
+
+	got := StripComments("#comment\n  ; indented\nsearch a#b c ;d\noptions\t#rotate", commentMarks...)
+	want := []string{"", "", "search a#b c", "options"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StripComments() = %q, want %q", got, want)
+	}
+}
+
+func TestResolverDialsNameserver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	f, err := ParseString("nameserver " + l.Addr().String() + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := f.Resolver().Dial(context.Background(), "tcp", "192.0.2.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != l.Addr().String() {
+		t.Errorf("Dial() connected to %v, want %v", conn.RemoteAddr(), l.Addr())
+	}
+}
+
+func TestRotationStart(t *testing.T) {
+	next := uint32(1<<31 - 1)
+	for _, want := range []int{1, 2, 0} {
+		if got := rotationStart(&next, 3); got != want {
+			t.Errorf("rotationStart() at %v = %v, want %v", next-1, got, want)
+		}
+	}
+
+	next = 1<<32 - 1
+	if got := rotationStart(&next, 3); got != 0 || next != 0 {
+		t.Errorf("rotationStart() at counter overflow = %v, counter %v", got, next)
+	}
+}
+
+func TestNameserversByFamily(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		wantV4  []string
+		wantV6  []string
+	}{
+		{name: "empty", content: ""},
+		{name: "ipv4 only", content: "nameserver 1.1.1.1\nnameserver 8.8.8.8\n", wantV4: []string{"1.1.1.1", "8.8.8.8"}},
+		{name: "ipv6 only", content: "nameserver ::1\nnameserver fe80::1%eth0\n", wantV6: []string{"::1", "fe80::1"}},
+		{
+			name:    "mixed",
+			content: "nameserver 2606:4700:4700::1111\nnameserver 1.1.1.1\nnameserver [2001:4860:4860::8888]:53\n",
+			wantV4:  []string{"1.1.1.1"},
+			wantV6:  []string{"2606:4700:4700::1111", "2001:4860:4860::8888"},
+		},
+		{name: "ipv4-mapped", content: "nameserver ::ffff:1.2.3.4\nnameserver ::1.2.3.4\n", wantV4: []string{"1.2.3.4"}, wantV6: []string{"::102:304"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseString(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ipStrings(f.NameserversV4()); !reflect.DeepEqual(got, tt.wantV4) {
+				t.Errorf("NameserversV4() = %q, want %q", got, tt.wantV4)
+			}
+			if got := ipStrings(f.NameserversV6()); !reflect.DeepEqual(got, tt.wantV6) {
+				t.Errorf("NameserversV6() = %q, want %q", got, tt.wantV6)
+			}
+		})
+	}
+}
+
+func ipStrings(ips []net.IP) []string {
+	var res []string
+	for _, ip := range ips {
+		res = append(res, ip.String())
+	}
+	return res
+}
+
+func TestTotalQueryBudget(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		want    time.Duration
+	}{
+		{content: "", want: 10 * time.Second},
+		{content: "nameserver 1.1.1.1\nnameserver 8.8.8.8\n", want: 20 * time.Second},
+		{content: "nameserver 1.1.1.1\nnameserver 8.8.8.8\noptions timeout:1 attempts:3\n", want: 6 * time.Second},
+		{content: "nameserver 1.1.1.1\nnameserver 1.0.0.1\nnameserver 8.8.8.8\nnameserver 8.8.4.4\noptions timeout:2\n", want: 12 * time.Second},
+		{content: "nameserver 1.1.1.1\noptions timeout:60 attempts:10\n", want: 150 * time.Second},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.TotalQueryBudget(); got != tt.want {
+			t.Errorf("%q: TotalQueryBudget() = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestFromNameservers(t *testing.T) {
+	f := FromNameservers([]net.IP{
+		net.ParseIP("1.1.1.1"),
+		nil,
+		net.ParseIP("2606:4700:4700::1111"),
+		net.ParseIP("8.8.8.8"),
+		net.ParseIP("8.8.4.4"),
+	}, []string{"example.com", "corp.local"})
+
+	want := "nameserver 1.1.1.1\nnameserver 2606:4700:4700::1111\nnameserver 8.8.8.8\nsearch example.com corp.local\n"
+	if string(f.Content) != want {
+		t.Errorf("Content = %q, want %q", f.Content, want)
+	}
+	if hash, _ := hashData(strings.NewReader(want)); f.Hash != hash {
+		t.Errorf("Hash = %v, want %v", f.Hash, hash)
+	}
+
+	f = FromNameservers(nil, []string{"example.com", "corp.local\nnameserver 7.7.7.7", ""})
+	if want := "search example.com\n"; string(f.Content) != want {
+		t.Errorf("Content with invalid search domains = %q, want %q", f.Content, want)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	want, err := ParseString("nameserver 8.8.8.8\nnameserver fe80::1%eth0\nsearch example.com\noptions ndots:2 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"nameservers":["8.8.8.8","fe80::1%eth0"]`) {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+
+	got := &File{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) || got.Hash != want.Hash {
+		t.Errorf("UnmarshalJSON() = %+v, want %+v", got, want)
+	}
+
+	for _, content := range []string{"search a.com\ndomain b.com\n", "domain b.com\nsearch a.com\n"} {
+		want, err := ParseString(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &File{}
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.ResolvesEquivalently(want) || got.QualifyName("x")[0] != want.QualifyName("x")[0] {
+			t.Errorf("JSON round trip of %q = %s, QualifyName() = %q, want %q", content, data, got.QualifyName("x"), want.QualifyName("x"))
+		}
+	}
+}
+
+func TestUnmarshalJSONInjection(t *testing.T) {
+	for _, data := range []string{
+		`{"domain":"corp.local\nnameserver 6.6.6.6"}`,
+		`{"options":["ndots:2\nsortlist 1.2.3.4"]}`,
+		`{"nameservers":["1.1.1.1 #comment"]}`,
+		`{"search":["a.com",";c.com"]}`,
+		`{"sortlist":["10.0.0.0/255.0.0.0\tnameserver"]}`,
+		`{"search":[""]}`,
+	} {
+		f := &File{}
+		if err := json.Unmarshal([]byte(data), f); err == nil {
+			t.Errorf("UnmarshalJSON(%s) = %+v, want error", data, f)
+		}
+	}
+}
+
+func TestSuspiciousNameservers(t *testing.T) {
+	for _, tt := range []struct {
+		ip         string
+		suspicious bool
+	}{
+		{ip: "0.0.0.0", suspicious: true},
+		{ip: "0.1.2.3", suspicious: true},
+		{ip: "255.255.255.255", suspicious: true},
+		{ip: "240.0.0.1", suspicious: true},
+		{ip: "224.0.0.251", suspicious: true},
+		{ip: "192.0.2.1", suspicious: true},
+		{ip: "198.51.100.53", suspicious: true},
+		{ip: "203.0.113.7", suspicious: true},
+		{ip: "::", suspicious: true},
+		{ip: "ff02::fb", suspicious: true},
+		{ip: "2001:db8::1", suspicious: true},
+		{ip: "1.1.1.1"},
+		{ip: "10.0.0.1"},
+		{ip: "127.0.0.53"},
+		{ip: "::1"},
+		{ip: "fe80::1"},
+		{ip: "2606:4700:4700::1111"},
+	} {
+		f := &File{Nameservers: []net.IP{net.ParseIP(tt.ip)}}
+		if got := len(f.SuspiciousNameservers()) == 1; got != tt.suspicious {
+			t.Errorf("SuspiciousNameservers() for %v reported %v, want %v", tt.ip, got, tt.suspicious)
+		}
+	}
+}
+
+func TestNormalizeDomainSearch(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		content    string
+		wantDomain string
+		wantSearch []string
+		wantNote   bool
+	}{
+		{name: "domain then search", content: "domain example.com\nsearch corp.local lab.local\n", wantSearch: []string{"corp.local", "lab.local"}, wantNote: true},
+		{name: "search then domain", content: "search corp.local lab.local\ndomain example.com\n", wantDomain: "example.com", wantSearch: []string{}, wantNote: true},
+		{name: "domain only", content: "domain example.com\n", wantDomain: "example.com", wantSearch: []string{}},
+		{name: "search only", content: "search corp.local\n", wantSearch: []string{"corp.local"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseString(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			note := f.NormalizeDomainSearch()
+			if (note != "") != tt.wantNote {
+				t.Errorf("NormalizeDomainSearch() note = %q, want note: %v", note, tt.wantNote)
+			}
+			if f.Domain != tt.wantDomain || !reflect.DeepEqual(f.Search, tt.wantSearch) {
+				t.Errorf("after NormalizeDomainSearch() Domain = %q, Search = %q, want %q, %q", f.Domain, f.Search, tt.wantDomain, tt.wantSearch)
+			}
+			if errs := f.Validate(); len(errs) != 0 {
+				t.Errorf("Validate() after NormalizeDomainSearch() = %v", errs)
+			}
+		})
+	}
+}
+
+func TestSearchAddedAfterDomain(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "domain only", content: "domain a.com\n", want: "domain a.com\nsearch b.com\n"},
+		{name: "search then domain", content: "search c.com # corp\ndomain a.com\n", want: "domain a.com\nsearch c.com b.com\n"},
+		{name: "domain then search", content: "domain a.com\nsearch c.com # corp\n", want: "domain a.com\nsearch c.com b.com # corp\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseString(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added, err := f.EnsureSearch("b.com"); !added || err != nil {
+				t.Fatalf("EnsureSearch() = %v, %v", added, err)
+			}
+
+			content, err := f.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("Marshal() = %q, want %q", content, tt.want)
+			}
+			if got := f.QualifyName("foo"); got[len(got)-2] != "foo.b.com." {
+				t.Errorf("QualifyName() = %q, want search used", got)
+			}
+
+			parsed, err := ParseBytes(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.ResolvesEquivalently(parsed) {
+				t.Errorf("file doesn't resolve equivalently to its marshaled content %q", content)
+			}
+
+			f.Canonicalize()
+			if f.Domain != "" || !containsDomain(f.Search, "b.com") {
+				t.Errorf("after Canonicalize() Domain = %q, Search = %q, want search kept", f.Domain, f.Search)
+			}
+		})
+	}
+
+	f, err := ParseString("search c.com\ndomain a.com # last\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Domain = "b.com"
+	if want := "search c.com\ndomain b.com # last\n"; f.String() != want {
+		t.Errorf("Marshal() with changed domain = %q, want %q", f.String(), want)
+	}
+}
+
+func TestParseDomainSearchWithoutArguments(t *testing.T) {
+	for _, tt := range []struct {
+		content    string
+		wantDomain string
+		wantSearch []string
+		want       string
+	}{
+		{content: "domain x.com\nsearch a.com\ndomain\n", wantDomain: "x.com", wantSearch: []string{"a.com"}, want: "host.a.com."},
+		{content: "search a.com b.com\nsearch\n", wantSearch: []string{"a.com", "b.com"}, want: "host.a.com."},
+		{content: "search a.com\ndomain x.com\nsearch\n", wantDomain: "x.com", wantSearch: []string{"a.com"}, want: "host.x.com."},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Domain != tt.wantDomain || !reflect.DeepEqual(f.Search, tt.wantSearch) {
+			t.Errorf("%q: Domain = %q, Search = %q, want %q, %q", tt.content, f.Domain, f.Search, tt.wantDomain, tt.wantSearch)
+		}
+		if got := f.QualifyName("host"); got[0] != tt.want {
+			t.Errorf("%q: QualifyName() = %q, want %v first", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSearchLimits(t *testing.T) {
+	// 4 domains of 63 characters with separators are 255 characters
+	long := strings.Repeat("a", 63)
+	for _, tt := range []struct {
+		name   string
+		search []string
+		code   string
+		value  string
+	}{
+		{name: "exactly 6 entries", search: []string{"a", "b", "c", "d", "e", "f"}},
+		{name: "7 entries", search: []string{"a", "b", "c", "d", "e", "f", "g"}, code: CodeTooManySearchDomains, value: "g"},
+		{name: "exactly 256 characters", search: []string{long, long, long, long + "b"}},
+		{name: "257 characters", search: []string{long, long, long, long + "bc"}, code: CodeSearchTooLong, value: long + "bc"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&File{Search: tt.search}).CheckSearchLimits()
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("CheckSearchLimits() = %v, want nil", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Code != tt.code || validationErr.Value != tt.value {
+				t.Errorf("CheckSearchLimits() = %#v, want code %v for %q", err, tt.code, tt.value)
+			}
+		})
+	}
+}
+
+func TestParseOptionsFlags(t *testing.T) {
+	f, err := ParseString("options rotate no-check-names inet6 edns0 single-request single-request-reopen\n" +
+		"options use-vc no-tld-query trust-ad no-reload future-flag ndots:3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := f.ParseOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ParsedOptions{
+		Ndots:               3,
+		Timeout:             defaultTimeout,
+		Attempts:            defaultAttempts,
+		Rotate:              true,
+		NoCheckNames:        true,
+		Inet6:               true,
+		EDNS0:               true,
+		SingleRequest:       true,
+		SingleRequestReopen: true,
+		UseVC:               true,
+		NoTLDQuery:          true,
+		TrustAD:             true,
+		NoReload:            true,
+		Unknown:             []string{"future-flag"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOptions() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseOptionsEDNS0Size(t *testing.T) {
+	for _, tt := range []struct {
+		options  []string
+		wantSize int
+		wantErr  bool
+	}{
+		{options: []string{"edns0"}},
+		{options: []string{"edns0:1232"}, wantSize: 1232},
+		{options: []string{"edns0:1232", "edns0:4096"}, wantSize: 4096},
+		{options: []string{"edns0:100000"}, wantSize: maxEDNS0Size, wantErr: true},
+		{options: []string{"edns0:big"}, wantErr: true},
+	} {
+		f := &File{Options: tt.options}
+		got, err := f.ParseOptions()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: ParseOptions() error = %v, want error: %v", tt.options, err, tt.wantErr)
+		}
+		if !got.EDNS0 || got.EDNS0Size != tt.wantSize {
+			t.Errorf("%q: EDNS0 = %v, EDNS0Size = %v, want true, %v", tt.options, got.EDNS0, got.EDNS0Size, tt.wantSize)
+		}
+	}
+}
+
+func TestSetRemoveOption(t *testing.T) {
+	f, err := ParseString("options ndots:5 rotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.SetOption("ndots", "2")
+	f.SetOption("ndots", "3")
+	f.SetOption("edns0", "")
+	if want := []string{"ndots:3", "rotate", "edns0"}; !reflect.DeepEqual(f.Options, want) {
+		t.Errorf("after SetOption() Options = %q, want %q", f.Options, want)
+	}
+
+	if !f.RemoveOption("rotate") {
+		t.Error("RemoveOption(rotate) = false, want true")
+	}
+	if f.RemoveOption("rotate") {
+		t.Error("RemoveOption(rotate) of removed option = true, want false")
+	}
+	if _, ok := f.OptionLines["rotate"]; ok {
+		t.Error("OptionLines still contains removed option")
+	}
+	if got, want := f.String(), "options ndots:3 edns0\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	duplicated := &File{Options: []string{"ndots:1", "rotate", "ndots:4"}}
+	duplicated.SetOption("ndots", "2")
+	if want := []string{"ndots:2", "rotate"}; !reflect.DeepEqual(duplicated.Options, want) {
+		t.Errorf("SetOption() over duplicates Options = %q, want %q", duplicated.Options, want)
+	}
+}
+
+func TestNumericOptionGetters(t *testing.T) {
+	for _, tt := range []struct {
+		options      []string
+		wantNdots    int
+		wantTimeout  time.Duration
+		wantAttempts int
+	}{
+		{wantNdots: 1, wantTimeout: 5 * time.Second, wantAttempts: 2},
+		{options: []string{"ndots:3", "timeout:1", "attempts:4"}, wantNdots: 3, wantTimeout: time.Second, wantAttempts: 4},
+		{options: []string{"ndots:20", "timeout:100", "attempts:9"}, wantNdots: 15, wantTimeout: 30 * time.Second, wantAttempts: 5},
+		{options: []string{"ndots:x", "timeout:", "attempts:-"}, wantNdots: 1, wantTimeout: 5 * time.Second, wantAttempts: 2},
+	} {
+		f := &File{Options: tt.options}
+		if got := f.Ndots(); got != tt.wantNdots {
+			t.Errorf("%q: Ndots() = %v, want %v", tt.options, got, tt.wantNdots)
+		}
+		if got := f.Timeout(); got != tt.wantTimeout {
+			t.Errorf("%q: Timeout() = %v, want %v", tt.options, got, tt.wantTimeout)
+		}
+		if got := f.Attempts(); got != tt.wantAttempts {
+			t.Errorf("%q: Attempts() = %v, want %v", tt.options, got, tt.wantAttempts)
+		}
+	}
+}
+
+func TestPreferIPv6(t *testing.T) {
+	for content, want := range map[string]bool{
+		"options inet6\n":                 true,
+		"options rotate\noptions inet6\n": true,
+		"options rotate\n":                false,
+		"":                                false,
+	} {
+		f, err := ParseString(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.PreferIPv6(); got != want {
+			t.Errorf("%q: PreferIPv6() = %v, want %v", content, got, want)
+		}
+	}
+}
+
+func TestTrustAD(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\noptions edns0 trust-ad\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.TrustAD() {
+		t.Error("TrustAD() = false, want true")
+	}
+
+	f.AddNameserver(net.ParseIP("8.8.8.8"))
+	content, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTripped, err := ParseBytes(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !roundTripped.TrustAD() {
+		t.Errorf("TrustAD() = false after round trip of %q", content)
+	}
+
+	if (&File{Options: []string{"edns0"}}).TrustAD() {
+		t.Error("TrustAD() = true without trust-ad option")
+	}
+}
+
+func TestClone(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\nsearch example.com\nsortlist 10.0.0.0/255.0.0.0\noptions ndots:2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := f.String()
+
+	c := f.Clone()
+	c.Nameservers[0][15] = 2
+	c.serverDetails[0].IP[15] = 2
+	c.Search[0] = "changed.com"
+	c.Sortlist[0].Address[0] = 11
+	c.Options[0] = "ndots:5"
+	c.Content[0] = 'N'
+
+	if f.String() != want || f.Content[0] != 'n' {
+		t.Errorf("modifying clone changed the original: %q", f.String())
+	}
+}
+
+func TestGetSpecificWithHasher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := GetSpecificWithHasher(path, md5.New(), "md5:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("md5:%x", md5.Sum([]byte("nameserver 1.1.1.1\n"))); f.Hash != want {
+		t.Errorf("Hash = %v, want %v", f.Hash, want)
+	}
+}
+
+func TestHashOf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// content which can't be parsed is hashed all the same
+	hash, err := HashOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hashBytes([]byte("nameserver 1.1.1.x\n")); hash != want {
+		t.Errorf("HashOf() = %v, want %v", hash, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := GetSpecific(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := HashOf(path); err != nil || hash != f.Hash {
+		t.Errorf("HashOf() = %v, %v, want %v", hash, err, f.Hash)
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	f, err := ParseString("nameserver 1.1.1.1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.VerifyHash(f.Hash); err != nil {
+		t.Errorf("VerifyHash() of parsed file = %v", err)
+	}
+
+	baseline := f.Hash
+	f.Content = append([]byte("nameserver 6.6.6.6\n"), f.Content...)
+	err = f.VerifyHash(baseline)
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("VerifyHash() of tampered content = %v, want ErrHashMismatch", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), baseline) {
+		t.Errorf("VerifyHash() error %q doesn't mention expected hash", err)
+	}
+}
+
+func TestHashBytesMatchesHashData(t *testing.T) {
+	data := []byte("nameserver 1.1.1.1\noptions ndots:2\n")
+	want, err := hashData(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashBytes(data); got != want {
+		t.Errorf("hashBytes() = %v, want %v", got, want)
+	}
+}
+
+// parseNameservers returns nameservers of content, as single-pass parser
+// returns them.
+func parseNameservers(content string) ([]Nameserver, error) {
+	f, err := ParseString(content)
+	if err != nil {
+		return nil, err
+	}
+	return f.Nameservers2(), nil
+}
+
+// parseMultiPass parses content the way it was done before single-pass
+// parser: every directive is collected with its own pass over all lines.
+func parseMultiPass(resolv []byte) (*File, error) {
+	servers, err := getNameservers(string(resolv))
+	if err != nil {
+		return nil, err
+	}
+	options, optionLines := getOptions(string(resolv))
+	sortlist, err := getSortlist(string(resolv))
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Content:       resolv,
+		Hash:          hashBytes(resolv),
+		Nameservers:   nameserverIPs(servers),
+		serverDetails: servers,
+		Options:       options,
+		OptionLines:   optionLines,
+		Search:        getSearchDomains(string(resolv)),
+		Domain:        getDomain(string(resolv)),
+		Sortlist:      sortlist,
+		Extras:        map[string][]string{},
+	}, nil
+}
+
+// getNameservers returns nameservers (if any) listed in /etc/resolv.conf
+func getNameservers(resolvConf string) ([]Nameserver, error) {
+	nameservers := []Nameserver{}
+	for _, line := range getNonEmptyLines(resolvConf, commentMarks...) {
+		args, ok := directiveArgs(line.text, nameserverKey)
+		if !ok || len(args) == 0 {
+			continue // skip if not nameserver
+		}
+
+		nameserver, err := nameserverFromArgs(line.number, args)
+		if err != nil {
+			return nil, err
+		}
+		nameservers = append(nameservers, nameserver)
+	}
+	return nameservers, nil
+}
+
+// directiveArgs splits line into fields and returns arguments of the
+// directive, if the first field is exactly key. Keyword must be separated from
+// arguments with whitespace, so "searching" is not a search directive.
+func directiveArgs(line, key string) ([]string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != key {
+		return nil, false
+	}
+	return fields[1:], true
+}
+
+// numberedLine is a line of input with its 1-based number.
+type numberedLine struct {
+	number int
+	text   string
+}
+
+// getNonEmptyLines is the same as getLines, but omits lines which are empty
+// after stripping comments and spaces, keeping numbers of remaining ones.
+func getNonEmptyLines(input string, commentMarkers ...string) []numberedLine {
+	var output []numberedLine
+	for i, line := range getLines(input, commentMarkers...) {
+		if line != "" {
+			output = append(output, numberedLine{number: i + 1, text: line})
+		}
+	}
+	return output
+}
+
+// getOptions returns options (if any) listed in /etc/resolv.conf
+// Options from all options lines are accumulated. If the same option appears
+// more than once, the later value overrides the earlier one, but keeps the
+// position where the option was first seen. Line numbers of options are
+// returned by their names.
+func getOptions(resolvConf string) ([]string, map[string]int) {
+	options := []string{}
+	lines := map[string]int{}
+	for _, line := range getNonEmptyLines(resolvConf, commentMarks...) {
+		args, ok := directiveArgs(line.text, optionKey)
+		if !ok {
+			continue // skip if not option
+		}
+
+		options = addOptions(options, lines, line.number, args)
+	}
+	return options, lines
+}
+
+// getSearchDomains returns search domains (if any) listed in /etc/resolv.conf
+// If more than one search line is encountered, only the contents of the last
+// one is returned, as libc does. The domain directive is not taken into
+// account here, so a search line always wins over it.
+func getSearchDomains(resolvConf string) []string {
+	domains := []string{}
+	for _, line := range getNonEmptyLines(resolvConf, commentMarks...) {
+		args, ok := directiveArgs(line.text, searchKey)
+		if !ok {
+			continue // skip if not search
+		}
+
+		domains = args
+	}
+	return domains
+}
+
+// getDomain returns the local domain name listed in /etc/resolv.conf, or empty
+// string if there is none. If more than one domain line is encountered, only
+// the last one is returned.
+func getDomain(resolvConf string) string {
+	domain := ""
+	for _, line := range getNonEmptyLines(resolvConf, commentMarks...) {
+		args, ok := directiveArgs(line.text, domainKey)
+		if !ok || len(args) == 0 {
+			continue // skip if not domain
+		}
+
+		domain = args[0]
+	}
+	return domain
+}
+
+// getSortlist returns sortlist pairs (if any) listed in /etc/resolv.conf
+// Pairs from all sortlist lines are accumulated, up to the maxSortlist.
+func getSortlist(resolvConf string) ([]SortlistPair, error) {
+	sortlist := []SortlistPair{}
+	for _, line := range getNonEmptyLines(resolvConf, commentMarks...) {
+		args, ok := directiveArgs(line.text, sortlistKey)
+		if !ok {
+			continue // skip if not sortlist
+		}
+
+		var err error
+		if sortlist, err = addSortlist(sortlist, line.number, args); err != nil {
+			return nil, err
+		}
+	}
+	return sortlist, nil
+}
+
+func largeResolvConf() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&b, "# entry %v\nnameserver 10.0.%v.%v\nsearch example%v.com\noptions ndots:%v\n", i, i/256%256, i%256, i, i%16)
+	}
+	return b.Bytes()
+}
+
+func TestParseLongLine(t *testing.T) {
+	search := strings.Repeat("a", 100<<10)
+	f, err := ParseString("nameserver 1.1.1.1\nsearch " + search + "\noptions ndots:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Search) != 1 || f.Search[0] != search || len(f.Options) != 1 {
+		t.Errorf("ParseString() of %v bytes line = %v search domains, %q", len(search), len(f.Search), f.Options)
+	}
+}
+
+func TestParseMatchesMultiPass(t *testing.T) {
+	content := largeResolvConf()
+	got, err := parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := parseMultiPass(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// multi-pass parser doesn't track layout and repeated options
+	got.lines, got.repeatedOptions = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Error("single-pass and multi-pass parsers returned different files")
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	content := largeResolvConf()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parse(content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseMultiPass(b *testing.B) {
+	content := largeResolvConf()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseMultiPass(content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRotatedNameservers(t *testing.T) {
+	a, b, c := net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8"), net.ParseIP("9.9.9.9")
+	f := &File{Nameservers: []net.IP{a, b, c}}
+
+	if got := f.RotatedNameservers(1); !reflect.DeepEqual(got, []net.IP{a, b, c}) {
+		t.Errorf("RotatedNameservers(1) without rotate = %v", got)
+	}
+
+	f.Options = []string{"rotate"}
+	for seed, want := range map[int][]net.IP{
+		0:  {a, b, c},
+		1:  {b, c, a},
+		5:  {c, a, b},
+		-1: {c, a, b},
+	} {
+		if got := f.RotatedNameservers(seed); !reflect.DeepEqual(got, want) {
+			t.Errorf("RotatedNameservers(%v) = %v, want %v", seed, got, want)
+		}
+	}
+}
+
+func TestHasOption(t *testing.T) {
+	f := &File{Options: []string{"ndots:5", "rotate", "ndots:2"}}
+	for _, tt := range []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{key: "ndots", value: "2", ok: true},
+		{key: "rotate", ok: true},
+		{key: "edns0"},
+	} {
+		if value, ok := f.HasOption(tt.key); value != tt.value || ok != tt.ok {
+			t.Errorf("HasOption(%q) = %q, %v, want %q, %v", tt.key, value, ok, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestMarshalCanonicalIPv6(t *testing.T) {
+	var outputs []string
+	for _, input := range []string{
+		"nameserver 2001:db8::0:1\nsortlist 2001:db8:0::/ffff:ffff::\n",
+		"nameserver 2001:0db8:0000::1\nsortlist 2001:db8::/ffff:ffff:0::\n",
+	} {
+		f, err := ParseString(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, f.String())
+	}
+
+	want := "nameserver 2001:db8::1\nsortlist 2001:db8::/ffff:ffff::\n"
+	for _, got := range outputs {
+		if got != want {
+			t.Errorf("Marshal() = %q, want %q", got, want)
+		}
+	}
+
+	built, err := new(Builder).AddNameserver(net.ParseIP("2001:db8::0:1")).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(built.Content); got != "nameserver 2001:db8::1\n" {
+		t.Errorf("Build() content = %q", got)
+	}
+}
+
+func TestTabSeparatedDirectives(t *testing.T) {
+	f, err := ParseString("nameserver\t\t1.1.1.1\n\tnameserver  \t8.8.8.8\t\nsearch\texample.com \t corp.local\noptions\t\tndots:2\trotate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")}; !reflect.DeepEqual(f.Nameservers, want) {
+		t.Errorf("Nameservers = %v, want %v", f.Nameservers, want)
+	}
+	if want := []string{"example.com", "corp.local"}; !reflect.DeepEqual(f.Search, want) {
+		t.Errorf("Search = %q, want %q", f.Search, want)
+	}
+	if want := []string{"ndots:2", "rotate"}; !reflect.DeepEqual(f.Options, want) {
+		t.Errorf("Options = %q, want %q", f.Options, want)
+	}
+}
+
+func TestSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := GetSpecific(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Source != SourceCustom {
+		t.Errorf("Source = %v, want %v", f.Source, SourceCustom)
+	}
+
+	if f, _ := ParseString("nameserver 1.1.1.1\n"); f.Source != SourceNone {
+		t.Errorf("Source = %v, want %v", f.Source, SourceNone)
+	}
+	if sourceOf(defaultPath) != SourceEtc || sourceOf(alternatePath) != SourceSystemd {
+		t.Error("sourceOf() doesn't recognize well-known paths")
+	}
+}
+
+func TestGetWithFallback(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.conf")
+	invalid := filepath.Join(dir, "invalid.conf")
+	valid := filepath.Join(dir, "valid.conf")
+	if err := ioutil.WriteFile(invalid, []byte("nameserver x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(valid, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := GetWithFallback(missing, invalid, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Nameservers[0].Equal(net.ParseIP("1.1.1.1")) {
+		t.Errorf("GetWithFallback() = %v", f)
+	}
+
+	_, err = GetWithFallback(missing, invalid)
+	var parseErr *ParseError
+	if !errors.Is(err, os.ErrNotExist) || !errors.As(err, &parseErr) {
+		t.Errorf("GetWithFallback() error = %v, want errors of both paths", err)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f := Empty()
+	parsed, err := ParseString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Hash != parsed.Hash || len(f.Content) != 0 || f.Nameservers == nil || f.Search == nil || f.Options == nil {
+		t.Errorf("Empty() = %#v", f)
+	}
+
+	f.AddNameserver(net.ParseIP("1.1.1.1"))
+	if got := f.String(); got != "nameserver 1.1.1.1\n" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	for content, want := range map[string]bool{
+		"":                                  true,
+		" \t\n\n   \n":                      true,
+		"# generated by dhclient\n; wait\n": true,
+		"nameserver 1.1.1.1\n":              false,
+		"search example.com\n":              false,
+		"domain example.com\n":              false,
+		"options rotate\n":                  false,
+	} {
+		f, err := ParseString(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.IsEmpty(); got != want {
+			t.Errorf("%q: IsEmpty() = %v, want %v", content, got, want)
+		}
+	}
+
+	if !Empty().IsEmpty() {
+		t.Error("Empty().IsEmpty() = false")
+	}
+}
+
+func TestSortAddresses(t *testing.T) {
+	f, err := ParseString("sortlist 10.1.0.0/255.255.0.0 10.0.0.0/255.0.0.0 192.168.1.0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := []net.IP{
+		net.ParseIP("8.8.8.8"),
+		net.ParseIP("10.2.0.1"),
+		net.ParseIP("192.168.1.7"),
+		net.ParseIP("10.1.0.1"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("10.3.0.1"),
+		net.ParseIP("10.1.5.5"),
+		net.ParseIP("1.1.1.1"),
+	}
+	want := []net.IP{
+		net.ParseIP("10.1.0.1"), // 10.1.0.0/16 is listed before overlapping 10.0.0.0/8
+		net.ParseIP("10.1.5.5"),
+		net.ParseIP("10.2.0.1"),
+		net.ParseIP("10.3.0.1"),
+		net.ParseIP("192.168.1.7"),
+		net.ParseIP("8.8.8.8"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("1.1.1.1"),
+	}
+	input := append([]net.IP{}, addrs...)
+
+	if got := f.SortAddresses(addrs); !reflect.DeepEqual(got, want) {
+		t.Errorf("SortAddresses() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(addrs, input) {
+		t.Errorf("SortAddresses() modified its input: %v", addrs)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	f, err := ParseString(`# generated
+options timeout:2 rotate
+nameserver 2001:db8::0:1
+nameserver 1.1.1.1 # primary
+domain example.com
+search corp.local CORP.local lab.local
+nameserver 2001:DB8::1
+options ndots:3 timeout:1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Canonicalize()
+	want := "nameserver 2001:db8::1\nnameserver 1.1.1.1\nsearch corp.local lab.local\noptions ndots:3 rotate timeout:1\n"
+	if got := f.String(); got != want {
+		t.Errorf("Canonicalize() = %q, want %q", got, want)
+	}
+
+	f.Canonicalize()
+	if got := f.String(); got != want {
+		t.Errorf("second Canonicalize() = %q, want %q", got, want)
+	}
+
+	reparsed, err := ParseString(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed.Canonicalize()
+	if got := reparsed.String(); got != want {
+		t.Errorf("Canonicalize() of canonical file = %q, want %q", got, want)
+	}
+}
+
+func TestQualifyName(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		name    string
+		want    []string
+	}{
+		{
+			content: "search example.com corp.local\n",
+			name:    "foo",
+			want:    []string{"foo.example.com.", "foo.corp.local.", "foo."},
+		},
+		{
+			content: "search example.com corp.local\n",
+			name:    "foo.bar",
+			want:    []string{"foo.bar.", "foo.bar.example.com.", "foo.bar.corp.local."},
+		},
+		{
+			content: "search example.com\noptions ndots:2\n",
+			name:    "foo.bar",
+			want:    []string{"foo.bar.example.com.", "foo.bar."},
+		},
+		{
+			content: "search example.com\n",
+			name:    "foo.",
+			want:    []string{"foo."},
+		},
+		{
+			content: "search example.com\noptions no-tld-query\n",
+			name:    "foo",
+			want:    []string{"foo.example.com."},
+		},
+		{
+			content: "search example.com\ndomain corp.local\n",
+			name:    "foo",
+			want:    []string{"foo.corp.local.", "foo."},
+		},
+		{
+			content: "nameserver 1.1.1.1\n",
+			name:    "foo",
+			want:    []string{"foo."},
+		},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.QualifyName(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: QualifyName(%q) = %q, want %q", tt.content, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestManagedBy(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		want    string
+	}{
+		{content: "# Generated by NetworkManager\nnameserver 192.168.1.1\n", want: "NetworkManager"},
+		{content: "# Dynamic resolv.conf(5) file for glibc resolver(3) generated by resolvconf(8)\n#     DO NOT EDIT THIS FILE BY HAND -- YOUR CHANGES WILL BE OVERWRITTEN\nnameserver 10.0.0.1\n", want: "resolvconf"},
+		{content: "# This is /run/systemd/resolve/stub-resolv.conf managed by man:systemd-resolved(8).\n# Do not edit.\n\nnameserver 127.0.0.53\n", want: "systemd-resolved"},
+		{content: "# Generated by dhcpcd from eth0.dhcp\nnameserver 10.0.0.1\n", want: "dhcpcd"},
+		{content: "# my own file\nnameserver 1.1.1.1\n"},
+		{content: "nameserver 1.1.1.1\n# Generated by NetworkManager\n"},
+	} {
+		f, err := ParseString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := f.ManagedBy(); got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%q: ManagedBy() = %q, %v, want %q", tt.content, got, ok, tt.want)
+		}
+	}
+}
+
+func TestExtras(t *testing.T) {
+	const content = "# BSD style\nnameserver 10.0.0.1\nlookup file bind\nfamily inet6 inet4\n"
+	f, err := ParseString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{"lookup": {"file", "bind"}, "family": {"inet6", "inet4"}}
+	if !reflect.DeepEqual(f.Extras, want) {
+		t.Errorf("Extras = %q, want %q", f.Extras, want)
+	}
+	if got := f.String(); got != content {
+		t.Errorf("String() = %q, want %q", got, content)
+	}
+
+	f.Extras["lookup"] = []string{"bind"}
+	if got, want := f.String(), "# BSD style\nnameserver 10.0.0.1\nlookup bind\nfamily inet6 inet4\n"; got != want {
+		t.Errorf("String() after changing Extras = %q, want %q", got, want)
+	}
+
+	built := &File{Nameservers: []net.IP{net.ParseIP("10.0.0.1")}, Extras: map[string][]string{"family": {"inet4"}, "lookup": {"file"}}}
+	if got, want := built.String(), "nameserver 10.0.0.1\nlookup file\nfamily inet4\n"; got != want {
+		t.Errorf("String() of built file = %q, want %q", got, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte(content+"bogus directive\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var parseErr *ParseError
+	if _, err := GetSpecificStrict(path); !errors.As(err, &parseErr) || parseErr.Line != 5 {
+		t.Errorf("GetSpecificStrict() error = %v, want error at line 5", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	corp, err := ParseString("# corp\nnameserver 10.0.0.1\nsearch corp.local\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := FromNameservers([]net.IP{net.ParseIP("192.168.1.1")}, nil)
+
+	var r Registry
+	r.Set("corp", corp)
+	r.Set("home", home)
+	corp.AddNameserver(net.ParseIP("10.0.0.2")) // registry keeps its own copy
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{name: "corp", want: "# corp\nnameserver 10.0.0.1\nsearch corp.local\n"},
+		{name: "home", want: "nameserver 192.168.1.1\n"},
+		{name: "corp", want: "# corp\nnameserver 10.0.0.1\nsearch corp.local\n"},
+	} {
+		if err := r.ActivateTo(tt.name, path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("ActivateTo(%q) wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if f, ok := r.Get("home"); !ok || !f.Equal(home) {
+		t.Errorf("Get(home) = %v, %v", f, ok)
+	}
+	if _, ok := r.Get("vpn"); ok {
+		t.Error("Get() of missing profile reported true")
+	}
+	if err := r.ActivateTo("vpn", path); err == nil {
+		t.Error("ActivateTo() of missing profile succeeded")
+	}
+}
+
+func TestCommentMeta(t *testing.T) {
+	f, err := ParseString(`# Generated by NetworkManager
+# interface=eth0
+# connection = Wired connection 1
+; source=dhcp
+# not metadata: a=b
+search example.com
+nameserver 192.168.1.1 # metric=100
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"interface":  "eth0",
+		"connection": "Wired connection 1",
+		"source":     "dhcp",
+		"metric":     "100",
+	}
+	if got := f.CommentMeta(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CommentMeta() = %q, want %q", got, want)
+	}
+
+	plain, err := ParseString("# just a comment\nnameserver 1.1.1.1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plain.CommentMeta(); got != nil {
+		t.Errorf("CommentMeta() of file without metadata = %q, want nil", got)
+	}
+}
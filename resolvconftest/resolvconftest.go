@@ -0,0 +1,22 @@
+// Package resolvconftest provides helpers for testing code which reads
+// resolv.conf files, kept apart so the main package doesn't import testing.
+package resolvconftest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// WriteTemp writes content to resolv.conf file in a temporary directory and
+// returns its path, e.g. for resolvconf.GetSpecific. The directory is removed
+// when the test and all its subtests complete. Write errors fail the test.
+func WriteTemp(t testing.TB, content string) (path string) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing temporary resolv.conf: %v", err)
+	}
+	return path
+}
@@ -0,0 +1,104 @@
+package resolvconf
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPort = "53"
+
+// Resolver returns resolver which sends queries to the nameservers of the
+// file, instead of the ones configured in the system.
+//
+// Nameservers are dialed in order of the file, next one is dialed only if
+// previous one can't be dialed, the whole list is tried up to attempts times.
+// With rotate option, every dial starts from the next nameserver. Dial timeout
+// is taken from timeout option. Nameservers without port are dialed at port 53.
+//
+// If the file has no nameservers, resolver with default settings is returned,
+// so it uses nameservers of the system.
+func (f *File) Resolver() *net.Resolver {
+	servers := f.servers()
+	if len(servers) == 0 {
+		return &net.Resolver{}
+	}
+
+	// errors are ignored, options are clamped and defaulted the way libc does
+	opts, _ := f.ParseOptions()
+	dialer := &net.Dialer{Timeout: time.Duration(opts.Timeout) * time.Second}
+	attempts := opts.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			start := 0
+			if opts.Rotate {
+				start = rotationStart(&next, len(servers))
+			}
+
+			var err error
+			for attempt := 0; attempt < attempts; attempt++ {
+				for i := range servers {
+					var conn net.Conn
+					conn, err = dialer.DialContext(ctx, network, servers[(start+i)%len(servers)].address())
+					if err == nil {
+						return conn, nil
+					}
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+				}
+			}
+			return nil, err
+		},
+	}
+}
+
+// rotationStart returns index of the nameserver to start the next dial from,
+// advancing counter next. Modulo is taken before conversion to int, so index
+// is in range even after the counter exceeds int on 32-bit platforms.
+func rotationStart(next *uint32, count int) int {
+	return int((atomic.AddUint32(next, 1) - 1) % uint32(count))
+}
+
+// TotalQueryBudget returns the longest time libc waits for a single query
+// before giving up:
+//
+//	timeout * attempts * nameservers
+//
+// where timeout and attempts are taken from options, defaulting to 5 seconds
+// and 2 attempts and clamped to libc limits, see ParseOptions, and nameservers
+// is the count of the ones libc uses, at most 3. Files without nameservers are
+// counted as one, since libc queries the local nameserver then. Invalid
+// numeric options are ignored, as libc does.
+//
+// Queries of names which are expanded with search domains take that long for
+// every name tried, see QualifyName.
+func (f *File) TotalQueryBudget() time.Duration {
+	opts, _ := f.ParseOptions()
+	nameservers := len(f.TruncatedNameservers())
+	if nameservers == 0 {
+		nameservers = 1
+	}
+	return time.Duration(opts.Timeout*opts.Attempts*nameservers) * time.Second
+}
+
+// address returns host:port address of the nameserver to dial.
+func (n Nameserver) address() string {
+	host := n.IP.String()
+	if n.Zone != "" {
+		host += "%" + n.Zone
+	}
+
+	port := n.Port
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(host, port)
+}
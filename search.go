@@ -0,0 +1,233 @@
+package resolvconf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeDomainSearch resolves the conflict of domain and search directives,
+// which are mutually exclusive: libc uses the one which is listed last. The
+// same one is kept and the other is cleared, so the file has unambiguous
+// search behavior. For files built from scratch, as well as for files where
+// search domains were added after parsing (e.g. by EnsureSearch), search is
+// kept, since Marshal writes it after domain.
+//
+// Returned note describes what was dropped, it's empty if there was no
+// conflict.
+func (f *File) NormalizeDomainSearch() (note string) {
+	if f.Domain == "" || len(f.Search) == 0 {
+		return ""
+	}
+
+	f.dirty = true
+	if f.domainLast {
+		note = "search " + strconv.Quote(strings.Join(f.Search, " ")) + " is dropped, domain " + strconv.Quote(f.Domain) + " is listed later"
+		f.Search = []string{}
+		return note
+	}
+
+	note = "domain " + strconv.Quote(f.Domain) + " is dropped, search is listed later"
+	f.Domain = ""
+	return note
+}
+
+// searchOrder returns lines and order of directives for renderLayout, so the
+// one of domain and search which wins (see domainLast) is written last. If
+// lines would put it before the other one, its lines are dropped, so it's
+// appended to the end.
+func (f *File) searchOrder(lines []line) ([]line, []string) {
+	if f.Domain == "" || len(f.Search) == 0 {
+		return lines, directiveOrder
+	}
+
+	searchAt := renderedAt(lines, searchKey, directive(searchKey, f.Search...))
+	domainAt := renderedAt(lines, domainKey, directive(domainKey, f.Domain))
+	winner, winnerAt, loserAt, order := searchKey, searchAt, domainAt, directiveOrder
+	if f.domainLast {
+		winner, winnerAt, loserAt, order = domainKey, domainAt, searchAt, domainLastOrder
+	}
+	if winnerAt == -1 || (loserAt != -1 && winnerAt > loserAt) {
+		return lines, order
+	}
+
+	kept := make([]line, 0, len(lines))
+	for _, l := range lines {
+		if l.key != winner {
+			kept = append(kept, l)
+		}
+	}
+	return kept, order
+}
+
+// renderedAt returns index of the line, which renderLayout replaces with the
+// only directive of key: the first line with the same content, or the first
+// line of key. Returns -1 if there are no lines of key, so the directive is
+// appended to the end.
+func renderedAt(lines []line, key, directive string) int {
+	first := -1
+	for i, l := range lines {
+		if l.key != key {
+			continue
+		}
+		if l.content == directive {
+			return i
+		}
+		if first == -1 {
+			first = i
+		}
+	}
+	return first
+}
+
+// searchList returns domains which libc appends to names: the search list,
+// truncated to 6 domains, or the domain, whichever is listed last.
+func (f *File) searchList() []string {
+	switch {
+	case f.Domain != "" && (len(f.Search) == 0 || f.domainLast):
+		return []string{f.Domain}
+	case len(f.Search) > maxSearch:
+		return f.Search[:maxSearch]
+	}
+	return f.Search
+}
+
+// QualifyName returns fully qualified names, which resolver tries for name, in
+// the order it tries them, the way glibc does:
+//
+//   - name with trailing dot is absolute and is tried as is only;
+//   - name with at least ndots dots is tried as is first, then with each
+//     search domain appended;
+//   - other names are tried with each search domain appended first, then as
+//     is, unless no-tld-query option is set and name has no dots at all.
+//
+// Search domains are taken from search or domain directive, whichever is
+// listed last. All returned names have trailing dot. If neither is set, glibc
+// uses the domain of the local hostname, which is not taken into account here.
+func (f *File) QualifyName(name string) []string {
+	if name == "" {
+		return nil
+	}
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	opts, _ := f.ParseOptions()
+	dots := strings.Count(name, ".")
+
+	var names []string
+	asIs := dots >= opts.Ndots
+	if asIs {
+		names = append(names, name+".")
+	}
+	for _, domain := range f.searchList() {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if !asIs && !(dots == 0 && opts.NoTLDQuery) {
+		names = append(names, name+".")
+	}
+	return names
+}
+
+// EnsureSearch appends domain to the search list, unless it's already there,
+// compared case-insensitively, and reports whether it was added. If the list
+// can't take one more domain without exceeding libc limits (see
+// CheckSearchLimits), it's left untouched and *ValidationError is returned.
+// Calling EnsureSearch repeatedly with the same domain is safe.
+//
+// Note that search directive, once added, overrides domain directive.
+func (f *File) EnsureSearch(domain string) (added bool, err error) {
+	if containsDomain(f.Search, domain) {
+		return false, nil
+	}
+
+	extended := &File{Search: append(append([]string{}, f.Search...), domain)}
+	if err := extended.CheckSearchLimits(); err != nil {
+		return false, err
+	}
+
+	f.Search = extended.Search
+	f.domainLast = false
+	f.dirty = true
+	return true, nil
+}
+
+// DedupeSearch removes repeated search domains, keeping the first one of each,
+// and returns the number of removed ones. Domains are compared
+// case-insensitively, as DNS names are.
+func (f *File) DedupeSearch() int {
+	kept := make([]string, 0, len(f.Search))
+	for _, domain := range f.Search {
+		if !containsDomain(kept, domain) {
+			kept = append(kept, domain)
+		}
+	}
+
+	removed := len(f.Search) - len(kept)
+	if removed > 0 {
+		f.Search = kept
+		f.dirty = true
+	}
+	return removed
+}
+
+// idnaProfile converts domains the way they're looked up, but allows
+// underscores and other characters which are common in internal domains.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// ToASCII converts international Domain and Search entries (e.g.
+// "münchen.example") to their ASCII form ("xn--mnchen-3ya.example"), which is
+// what resolver sends in queries. ASCII entries are left as they are.
+//
+// If any entry is not a valid international domain name, error naming it is
+// returned and f is left untouched.
+func (f *File) ToASCII() error {
+	domain, err := domainToASCII(f.Domain)
+	if err != nil {
+		return fmt.Errorf("domain %q: %w", f.Domain, err)
+	}
+
+	search := make([]string, len(f.Search))
+	for i, entry := range f.Search {
+		if search[i], err = domainToASCII(entry); err != nil {
+			return fmt.Errorf("search domain %q: %w", entry, err)
+		}
+	}
+
+	if domain != f.Domain || !equalStrings(search, f.Search) {
+		f.Domain, f.Search = domain, search
+		f.dirty = true
+	}
+	return nil
+}
+
+func domainToASCII(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	return idnaProfile.ToASCII(domain)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
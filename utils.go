@@ -3,13 +3,70 @@ package resolvconf
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"os"
 )
 
+// hashBytes returns the same as hashData does, but without copying data which
+// is already in memory.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
 func hashData(src io.Reader) (string, error) {
-	h := sha256.New()
+	return hashDataWith(src, sha256.New(), "sha256:")
+}
+
+// HashOf returns the hash of the file at path the same way as it's computed
+// for File.Hash by GetSpecific, without parsing the file. It's a cheap way to
+// check whether the file changed since it was read.
+func HashOf(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hashData(f)
+}
+
+// ErrHashMismatch is returned by VerifyHash when Content doesn't match the
+// expected hash.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// VerifyHash recomputes sha256 hash of Content, the same way as it's computed
+// for Hash, and returns error wrapping ErrHashMismatch if it differs from
+// expected, e.g. a hash stored earlier or returned by HashOf. Hash field
+// itself is not trusted, so VerifyHash(f.Hash) detects Content and Hash
+// drifting apart.
+func (f *File) VerifyHash(expected string) error {
+	if actual := hashBytes(f.Content); actual != expected {
+		return fmt.Errorf("%w: content has %v, expected %v", ErrHashMismatch, actual, expected)
+	}
+	return nil
+}
+
+// hashDataWith hashes src with h, which is reset before use, and returns the
+// hex-encoded digest with prefix, e.g. "md5:".
+func hashDataWith(src io.Reader, h hash.Hash, prefix string) (string, error) {
+	h.Reset()
 	if _, err := io.Copy(h, src); err != nil {
 		return "", err
 	}
-	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+	return prefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// StripComments splits input into lines, strips comments started with any of
+// markers at the line start or after whitespace, and trims surrounding
+// whitespace of each line. It returns one entry per input line, including
+// blank ones, so indexes match line numbers (starting from 0).
+//
+// These are exactly the semantics used to parse resolv.conf (with "#" and ";"
+// markers), so it can be used for any similar config file.
+func StripComments(input string, markers ...string) []string {
+	return getLines(input, markers...)
 }
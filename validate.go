@@ -0,0 +1,178 @@
+package resolvconf
+
+import (
+	"net"
+	"strconv"
+)
+
+// Codes of problems reported by Validate.
+const (
+	CodeTooManyNameservers   = "too-many-nameservers"
+	CodeTooManySearchDomains = "too-many-search-domains"
+	CodeSearchTooLong        = "search-too-long"
+	CodeNdotsTooLarge        = "ndots-too-large"
+	CodeDuplicateOption      = "duplicate-option"
+	CodeDomainAndSearch      = "domain-and-search"
+	CodeDuplicateSearch      = "duplicate-search"
+	CodeByteOrderMark        = "byte-order-mark"
+)
+
+// maxSearchLength is the maximum length of the search list used by libc,
+// including separators. (MAXDNSRCHPATH)
+const maxSearchLength = 256
+
+// ValidationError is a problem reported by Validate.
+type ValidationError struct {
+	Code    string // one of Code* constants
+	Value   string // offending value
+	Message string // human-readable description
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message + ": " + strconv.Quote(e.Value)
+}
+
+// Is reports whether target is ValidationError with the same code, so
+// errors.Is(err, ErrTooManyNameservers) works for any offending value.
+func (e *ValidationError) Is(target error) bool {
+	t, ok := target.(*ValidationError)
+	return ok && t.Code == e.Code
+}
+
+// ErrTooManyNameservers is reported by Validate when file contains more
+// nameservers than libc uses.
+var ErrTooManyNameservers error = &ValidationError{Code: CodeTooManyNameservers, Message: "too many nameservers"}
+
+// CheckSearchLimits checks the search list against libc limits: at most 6
+// domains, at most 256 characters including separators. Longer lists are
+// silently truncated by libc. Returned *ValidationError contains the first
+// domain which would be dropped, nil is returned if list fits the limits.
+func (f *File) CheckSearchLimits() error {
+	length := 0
+	for i, domain := range f.Search {
+		if i >= maxSearch {
+			return &ValidationError{
+				Code:    CodeTooManySearchDomains,
+				Value:   domain,
+				Message: "too many search domains, only first " + strconv.Itoa(maxSearch) + " are used",
+			}
+		}
+
+		if i > 0 {
+			length++ // separator
+		}
+		length += len(domain)
+		if length > maxSearchLength {
+			return &ValidationError{
+				Code:    CodeSearchTooLong,
+				Value:   domain,
+				Message: "search list is longer than " + strconv.Itoa(maxSearchLength) + " characters",
+			}
+		}
+	}
+	return nil
+}
+
+// Validate reports problems of the file, which don't prevent parsing, but make
+// resolver behave differently from what the file declares. All returned errors
+// are *ValidationError. Returns nil if there is none.
+func (f *File) Validate() []error {
+	var warnings []error
+	warn := func(code, value, message string) {
+		warnings = append(warnings, &ValidationError{Code: code, Value: value, Message: message})
+	}
+
+	if f.bom {
+		warn(CodeByteOrderMark, byteOrderMark, "file starts with byte order mark, which libc doesn't expect")
+	}
+	if limit := f.nameserverLimit(); len(f.Nameservers) > limit {
+		warn(CodeTooManyNameservers, f.Nameservers[limit].String(),
+			"too many nameservers, only first "+strconv.Itoa(limit)+" are used")
+	}
+
+	if err := f.CheckSearchLimits(); err != nil {
+		warnings = append(warnings, err)
+	}
+	for i, domain := range f.Search {
+		if containsDomain(f.Search[:i], domain) {
+			warn(CodeDuplicateSearch, domain, "search domain is listed more than once")
+		}
+	}
+	if f.Domain != "" && len(f.Search) > 0 {
+		warn(CodeDomainAndSearch, f.Domain, "domain and search are mutually exclusive")
+	}
+
+	// parser collapses repeated options, they're still in Content until the
+	// file is rendered again
+	if !f.dirty {
+		for _, option := range f.repeatedOptions {
+			warn(CodeDuplicateOption, option, "option is set more than once")
+		}
+	}
+	seen := make(map[string]bool, len(f.Options))
+	for _, option := range f.Options {
+		name := optionName(option)
+		if seen[name] {
+			warn(CodeDuplicateOption, option, "option is set more than once")
+		}
+		seen[name] = true
+
+		if name == "ndots" && name != option {
+			if n, err := strconv.Atoi(option[len(name)+1:]); err == nil && n > maxNdots {
+				warn(CodeNdotsTooLarge, option, "ndots is greater than "+strconv.Itoa(maxNdots))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// unroutableNetworks are reserved blocks which can't contain a working
+// nameserver: "this network", documentation and reserved ranges, see RFC 6890.
+var unroutableNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4", // including 255.255.255.255
+	"2001:db8::/32",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// SuspiciousNameservers returns nameservers pointing at addresses which can't
+// serve DNS: unspecified, multicast, broadcast, documentation and other
+// reserved ranges. Such addresses usually come from a broken template. The
+// check is advisory, loopback and link-local addresses are not reported, as
+// they are valid for local resolvers.
+func (f *File) SuspiciousNameservers() []net.IP {
+	var suspicious []net.IP
+	for _, ip := range f.Nameservers {
+		if isUnroutable(ip) {
+			suspicious = append(suspicious, ip)
+		}
+	}
+	return suspicious
+}
+
+func isUnroutable(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range unroutableNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package resolvconf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch watches the file at path and sends its parsed content every time the
+// hash of the file changes. Current content of the file is sent first.
+//
+// Parent directory is watched instead of the file itself, so replacing the
+// file (e.g. atomic rename used by editors, DHCP clients and WriteFile) is
+// handled the same way as in-place writes. Read and parse errors are sent to
+// the error channel, watching continues after them, except the case when
+// watcher itself can't be started.
+//
+// Both channels are closed when ctx is cancelled.
+func Watch(ctx context.Context, path string) (<-chan *File, <-chan error) {
+	files := make(chan *File)
+	errs := make(chan error, 1)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		errs <- err
+		close(files)
+		close(errs)
+		return files, errs
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		errs <- err
+		close(files)
+		close(errs)
+		return files, errs
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(files)
+		defer watcher.Close()
+
+		var lastHash string
+		reload := func() bool {
+			f, err := GetSpecific(path)
+			switch {
+			case os.IsNotExist(err):
+				return true // file is being replaced, waiting for it to be created
+			case err != nil:
+				return sendError(ctx, errs, err)
+			case f.Hash == lastHash:
+				return true
+			}
+
+			lastHash = f.Hash
+			select {
+			case files <- f:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !reload() {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if !reload() {
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				if !sendError(ctx, errs, err) {
+					return
+				}
+			}
+		}
+	}()
+
+	return files, errs
+}
+
+// sendError sends err to errs and reports whether it was sent before ctx was
+// done.
+func sendError(ctx context.Context, errs chan<- error, err error) bool {
+	select {
+	case errs <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,140 @@
+package resolvconf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// defaultFileMode is the mode of newly created resolv.conf files.
+const defaultFileMode os.FileMode = 0644
+
+// lockSuffix is appended to the path of written file to get its lock file.
+const lockSuffix = ".lock"
+
+// ErrLockUnsupported is returned by WriteFileLocked on platforms without
+// flock(2).
+var ErrLockUnsupported = errors.New("file locking is not supported on this platform")
+
+// WriteFileLocked is the same as WriteFile, but holds exclusive advisory lock
+// on the lock file path+".lock" (e.g. /etc/resolv.conf.lock) while writing, so
+// concurrent writers using the same lock don't interleave their updates. The
+// lock is taken with flock(2) (syscall.Flock), waiting for other writers to
+// release it. Lock file is created if needed and is left in place afterwards.
+//
+// Locking is advisory: writers which don't take the lock, e.g. DHCP clients
+// unaware of it, are not stopped. On platforms without flock
+// ErrLockUnsupported is returned and nothing is written.
+func (f *File) WriteFileLocked(path string) (err error) {
+	unlock, err := lockFile(path + lockSuffix)
+	if err != nil {
+		return fmt.Errorf("writing %v: %w", path, err)
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil && err == nil {
+			err = fmt.Errorf("writing %v: unlocking: %w", path, unlockErr)
+		}
+	}()
+
+	return f.WriteFile(path)
+}
+
+// WriteFile atomically writes marshaled file to path. Content is written to a
+// temporary file in the same directory, synced to disk, and then renamed over
+// path, so readers never see a partially written file.
+//
+// If path already exists, its mode and ownership are preserved, otherwise new
+// file is created with 0644 mode.
+//
+// Files which weren't changed since they were read are written as their
+// original Content byte for byte, so reading and writing back a file doesn't
+// rewrite its spacing or line endings. The file is reserialized with Marshal
+// once it's changed by any of the mutators (AddNameserver, RemoveNameserver
+// and so on), or when its fields were modified directly and no longer match
+// Content.
+func (f *File) WriteFile(path string) error {
+	content, err := f.contentToWrite()
+	if err != nil {
+		return fmt.Errorf("writing %v: marshaling: %w", path, err)
+	}
+
+	mode := defaultFileMode
+	existing, err := os.Stat(path)
+	switch {
+	case err == nil:
+		mode = existing.Mode().Perm()
+	case !os.IsNotExist(err):
+		return fmt.Errorf("writing %v: %w", path, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing %v: creating temp file: %w", path, err)
+	}
+	// removing temp file in case of any error, after successful rename it
+	// doesn't exist anymore
+	defer os.Remove(tmp.Name())
+
+	if err := writeTemp(tmp, content, mode, existing); err != nil {
+		return fmt.Errorf("writing %v: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing %v: renaming temp file: %w", path, err)
+	}
+	return nil
+}
+
+// contentToWrite returns original Content if the file wasn't changed,
+// otherwise marshaled file.
+func (f *File) contentToWrite() ([]byte, error) {
+	if f.dirty || f.Content == nil {
+		return f.Marshal()
+	}
+
+	// fields are exported, so they could be changed without mutators
+	content, err := f.Marshal()
+	original, parseErr := parse(f.Content)
+	if parseErr != nil {
+		return content, err
+	}
+	rendered, renderErr := original.Marshal()
+	switch {
+	case err == nil && renderErr == nil && bytes.Equal(rendered, content):
+	case err != nil && renderErr != nil && err.Error() == renderErr.Error():
+		// fields are the same as parsed, they just can't be marshaled
+	default:
+		return content, err
+	}
+	return f.Content, nil
+}
+
+// writeTemp writes content to tmp, applies mode and ownership of existing
+// file (if any), syncs and closes tmp.
+func writeTemp(tmp *os.File, content []byte, mode os.FileMode, existing os.FileInfo) error {
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("setting mode: %w", err)
+	}
+	if existing != nil {
+		if err := chownAs(tmp, existing); err != nil {
+			tmp.Close()
+			return fmt.Errorf("setting ownership: %w", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package resolvconf
+
+import "os"
+
+// chownAs is a no-op on platforms without unix ownership.
+func chownAs(f *os.File, existing os.FileInfo) error {
+	return nil
+}
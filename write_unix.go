@@ -0,0 +1,17 @@
+//go:build unix
+
+package resolvconf
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownAs changes ownership of f to the owner of existing file.
+func chownAs(f *os.File, existing os.FileInfo) error {
+	stat, ok := existing.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(stat.Uid), int(stat.Gid))
+}